@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/goUtils"
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestAttach starts the 512x512 image for 100 turns on a broker on port 8090 with 2 servers from a
+// controller that leaves after turn 10. A second controller attaches and has to see the same cells
+// as an uninterrupted run, from the world it is given through to the final turn.
+func TestAttach(t *testing.T) {
+	brokerAddr, stop := startProcessCluster(t, 8090, 2)
+	defer stop()
+
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 100, BrokerAddr: brokerAddr}
+
+	// The first controller starts the game, then detaches and hangs up without waiting for the end.
+	first, err := rpc.Dial("tcp", p.BrokerAddr)
+	util.Check(err)
+	req := stubs.Request{
+		World:  readCellValues("images/512x512.pgm", 512, 512),
+		Params: goUtils.Params{Turns: p.Turns, ImageWidth: p.ImageWidth, ImageHeight: p.ImageHeight},
+	}
+	loaded := new(stubs.Response)
+	util.Check(first.Call(stubs.LoadWorldToBroker, req, loaded))
+	session := stubs.Request{Session: loaded.Session}
+	first.Go(stubs.CallServerProcessWorld, session, new(stubs.Response), nil)
+	for {
+		res := new(stubs.Response)
+		util.Check(first.Call(stubs.AggregateCellNumbers, session, res))
+		if res.Turn >= 10 {
+			if res.Turn == p.Turns {
+				t.Fatalf("The game finished before the first controller could leave")
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	util.Check(first.Call(stubs.DetachClient, session, new(stubs.Response)))
+	first.Close()
+
+	attached, err := gol.SessionParams(gol.Params{BrokerAddr: p.BrokerAddr})
+	util.Check(err)
+	if attached.ImageWidth != p.ImageWidth || attached.ImageHeight != p.ImageHeight || attached.Turns != p.Turns {
+		t.Fatalf("Expected the session to be %dx%dx%d, got %dx%dx%d",
+			p.ImageWidth, p.ImageHeight, p.Turns, attached.ImageWidth, attached.ImageHeight, attached.Turns)
+	}
+	attached.Attach = true
+
+	alive := readAliveCounts(512, 512)
+	board := make([][]bool, p.ImageHeight)
+	for y := range board {
+		board[y] = make([]bool, p.ImageWidth)
+	}
+	count := 0
+	firstTurn := -1
+	var cells []util.Cell
+	events := make(chan gol.Event)
+	go gol.Run(attached, events, nil)
+	for event := range events {
+		switch e := event.(type) {
+		case gol.CellsFlipped:
+			if firstTurn == -1 {
+				firstTurn = e.CompletedTurns
+			}
+			for _, cell := range e.Cells {
+				board[cell.Y][cell.X] = !board[cell.Y][cell.X]
+				if board[cell.Y][cell.X] {
+					count++
+				} else {
+					count--
+				}
+			}
+		case gol.TurnComplete:
+			if count != alive[e.CompletedTurns] {
+				t.Errorf("Incorrect number of alive cells on turn %d. Was %d, should be %d.", e.CompletedTurns, count, alive[e.CompletedTurns])
+			}
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	if firstTurn < 10 {
+		t.Errorf("Expected the attached controller to start after turn 10, but it started at turn %d", firstTurn)
+	}
+	if count != alive[p.Turns] {
+		t.Errorf("Incorrect number of alive cells at the end. Was %d, should be %d.", count, alive[p.Turns])
+	}
+	expectedAlive := readAliveCells("check/images/512x512x100.pgm", 512, 512)
+	assertEqualBoard(t, cells, expectedAlive, p)
+}
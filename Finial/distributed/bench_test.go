@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"uk.ac.bris.cs/gameoflife/gol"
@@ -30,3 +34,46 @@ func BenchmarkStudentVersion(b *testing.B) {
 		})
 	}
 }
+
+const serversBenchLength = 100
+
+// BenchmarkServers runs the 512x512 image on 1, 2, 4 and 8 local server processes.
+// It starts the servers on ports 8040 upwards and the broker on 8034, so no other
+// broker can be running at the same time.
+func BenchmarkServers(b *testing.B) {
+	dir := buildCluster(b)
+	defer os.RemoveAll(dir)
+
+	for _, servers := range []int{1, 2, 4, 8} {
+		var processes []*exec.Cmd
+		var addrs []string
+		for i := 0; i < servers; i++ {
+			addr := "127.0.0.1:" + strconv.Itoa(8040+i)
+			processes = append(processes, startProcess(b, addr, filepath.Join(dir, "server"), "-port", strconv.Itoa(8040+i)))
+			addrs = append(addrs, addr)
+		}
+		processes = append(processes, startProcess(b, "127.0.0.1:8034", filepath.Join(dir, "broker"), "-servers", strings.Join(addrs, ",")))
+
+		os.Stdout = nil // Disable all program output apart from benchmark results
+		p := gol.Params{
+			Turns:       serversBenchLength,
+			Threads:     1,
+			ImageWidth:  512,
+			ImageHeight: 512,
+		}
+		name := fmt.Sprintf("%dx%dx%d-%dservers", p.ImageWidth, p.ImageHeight, p.Turns, servers)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				events := make(chan gol.Event)
+				go gol.Run(p, events, nil)
+				for range events {
+				}
+			}
+		})
+
+		for _, process := range processes {
+			process.Process.Kill()
+			process.Wait()
+		}
+	}
+}
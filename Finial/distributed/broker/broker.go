@@ -1,85 +1,196 @@
-package main
+package broker
 
 import (
+	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/rpc"
-	"os"
 	"sync"
+	"time"
 	"uk.ac.bris.cs/gameoflife/goUtils"
 	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
+// session is one world on the broker. Its fields are guarded by the broker's dataLock.
+// The servers hold the world while it is processed; the broker only keeps the
+// last snapshot of it, taken when a controller asks for the world and every
+// snapshotInterval, so a world can carry on from it if a server fails.
+type session struct {
+	id        int
+	params    goUtils.Params
+	world     [][]uint8 // the world after worldTurn
+	worldTurn int
+	turn      int
+	cellnum   int
+	shown     [][]uint8 // the world the controller was last sent, to work out the cells that changed
+	attached  bool      // whether a controller is collecting the cells that change
+	attaches  int       // the number of times AttachClient has been called, so a controller that was taken over from can tell
+	isPause   bool
+	runTo     int           // while paused, turns are still run up to this one
+	stepping  bool          // the servers are computing turn+1
+	delay     time.Duration // the time to wait after each turn
+	started   bool          // CallServerProcessWorld has been called
+	killed    bool
+
+	// The servers holding the world while it is processed, and the snapshots
+	// being waited for or taken from them.
+	clients   []*rpc.Client
+	wantWorld int
+	fetching  bool
+
+	// changed is signalled on the broker's dataLock whenever isPause, runTo, turn,
+	// stepping, killed, err, wantWorld or fetching change.
+	changed *sync.Cond
+
+	// finished is closed once the world is done, with err set if it failed.
+	finished chan bool
+	err      error
+}
+
 type Broker struct {
-	params      goUtils.Params
-	world       [][]uint8
-	turn        int
+	sessions    map[int]*session
+	lastSession int // the ID of the newest session
+	stopped     bool
 	dataLock    sync.Mutex
-	serverAddrs []string
+	serverAddrs []string // the pool of servers, used from the start of the next world
+
+	shutDown context.CancelFunc
 }
 
-func (b *Broker) ShutDownAllServers(req *stubs.Request, res *stubs.Response) error {
-	var wg sync.WaitGroup
+// Serve runs a broker for the servers at serverAddrs on listener until ctx is done
+// or a controller calls ShutDownAllServers. Every session still running is killed
+// after the turn being computed.
+func Serve(ctx context.Context, listener net.Listener, serverAddrs []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := &Broker{sessions: make(map[int]*session), serverAddrs: serverAddrs, shutDown: cancel}
+	go func() {
+		<-ctx.Done()
+		b.stopSessions()
+	}()
+	return stubs.Serve(ctx, listener, b)
+}
 
-	for _, addr := range b.serverAddrs {
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-			client, err := rpc.Dial("tcp", addr)
-			if err != nil {
-				log.Printf("Error connecting to server %s: %v", addr, err)
-				return
-			}
-			defer client.Close()
+// stopSessions kills every session and stops new ones from being made. It
+// returns channels that are closed once each session has stopped.
+func (b *Broker) stopSessions() []chan bool {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	b.stopped = true
+	var finished []chan bool
+	for id, s := range b.sessions {
+		b.kill(s)
+		delete(b.sessions, id)
+		finished = append(finished, s.finished)
+	}
+	return finished
+}
 
-			shutDownRes := new(stubs.Response)
-			err = client.Call(stubs.ShotDown, req, shutDownRes)
-			if err != nil {
-				log.Printf("Error calling ShotDown on server %s: %v", addr, err)
-			}
-		}(addr)
+// kill stops a session after the turn being computed. dataLock must be held.
+func (b *Broker) kill(s *session) {
+	s.killed = true
+	s.changed.Broadcast()
+	if !s.started {
+		s.err = fmt.Errorf("session %d was killed", s.id)
+		close(s.finished)
 	}
+}
 
-	wg.Wait()
-	os.Exit(0)
-	return nil
+// newSession adds a session for a world that is at turn. dataLock must be held.
+func (b *Broker) newSession(p goUtils.Params, world [][]uint8, turn int) (*session, error) {
+	if b.stopped {
+		return nil, errors.New("the broker is shutting down")
+	}
+	b.lastSession++
+	s := &session{
+		id:        b.lastSession,
+		params:    p,
+		world:     copyWorld(world),
+		worldTurn: turn,
+		turn:      turn,
+		cellnum:   countCell(world),
+		finished:  make(chan bool),
+		changed:   sync.NewCond(&b.dataLock),
+	}
+	b.sessions[s.id] = s
+	return s, nil
+}
 
+// session returns the session with the given ID, or the newest one for 0.
+// dataLock must be held.
+func (b *Broker) session(id int) (*session, error) {
+	if id == 0 {
+		id = b.lastSession
+	}
+	s, ok := b.sessions[id]
+	if !ok {
+		if id == 0 {
+			return nil, errors.New("no world has been processed")
+		}
+		return nil, fmt.Errorf("no session %d", id)
+	}
+	return s, nil
 }
 
-func (b *Broker) PauseAllServers(req *stubs.Request, res *stubs.Response) error {
-	var wg sync.WaitGroup
+// While a world is processed the broker pings its servers every heartbeatInterval.
+// A server that does not answer within heartbeatTimeout is taken to have failed.
+const (
+	heartbeatInterval = 500 * time.Millisecond
+	heartbeatTimeout  = 2 * time.Second
+)
 
-	for _, addr := range b.serverAddrs {
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-			client, err := rpc.Dial("tcp", addr)
-			if err != nil {
-				log.Printf("Error connecting to server %s: %v", addr, err)
-				return
-			}
-			defer client.Close()
+// servers returns a copy of the current server pool.
+func (b *Broker) servers() []string {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	return append([]string(nil), b.serverAddrs...)
+}
 
-			pauseRes := new(stubs.Response)
-			err = client.Call(stubs.Pause, req, pauseRes)
-			if err != nil {
-				log.Printf("Error calling Pause on server %s: %v", addr, err)
-			}
-		}(addr)
+// RegisterServer adds the server at req.ServerAddr to the pool. A world that is
+// already running keeps its servers; the new one is used from the next world.
+func (b *Broker) RegisterServer(req *stubs.Request, res *stubs.Response) error {
+	if req.ServerAddr == "" {
+		return errors.New("no server address")
 	}
-
-	wg.Wait()
-
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	for _, addr := range b.serverAddrs {
+		if addr == req.ServerAddr {
+			return nil
+		}
+	}
+	b.serverAddrs = append(b.serverAddrs, req.ServerAddr)
+	fmt.Println("Registered server", req.ServerAddr)
 	return nil
 }
 
-func (b *Broker) UnPauseAllServers(req *stubs.Request, res *stubs.Response) error {
+// DeregisterServer removes the server at req.ServerAddr from the pool.
+func (b *Broker) DeregisterServer(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	for i, addr := range b.serverAddrs {
+		if addr == req.ServerAddr {
+			b.serverAddrs = append(b.serverAddrs[:i], b.serverAddrs[i+1:]...)
+			fmt.Println("Deregistered server", req.ServerAddr)
+			return nil
+		}
+	}
+	return fmt.Errorf("server %s is not registered", req.ServerAddr)
+}
+
+// ShutDownAllServers lets every session finish the turn it is on, then shuts
+// down every server in the pool and the broker itself, once it has replied.
+func (b *Broker) ShutDownAllServers(req *stubs.Request, res *stubs.Response) error {
+	for _, finished := range b.stopSessions() {
+		<-finished
+	}
+
 	var wg sync.WaitGroup
 
-	for _, addr := range b.serverAddrs {
+	for _, addr := range b.servers() {
 		wg.Add(1)
 		go func(addr string) {
 			defer wg.Done()
@@ -90,246 +201,769 @@ func (b *Broker) UnPauseAllServers(req *stubs.Request, res *stubs.Response) erro
 			}
 			defer client.Close()
 
-			unpauseRes := new(stubs.Response)
-			err = client.Call(stubs.UnPause, req, unpauseRes)
+			shutDownRes := new(stubs.Response)
+			err = client.Call(stubs.ShotDown, req, shutDownRes)
 			if err != nil {
-				log.Printf("Error calling UnPause on server %s: %v", addr, err)
+				log.Printf("Error calling ShotDown on server %s: %v", addr, err)
 			}
 		}(addr)
 	}
 
 	wg.Wait()
-
+	b.shutDown()
 	return nil
 }
 
-func (b *Broker) DisconnectAllServers(req *stubs.Request, res *stubs.Response) error {
-	var wg sync.WaitGroup
-
-	for _, addr := range b.serverAddrs {
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-			client, err := rpc.Dial("tcp", addr)
-			if err != nil {
-				log.Printf("Error connecting to server %s: %v", addr, err)
-				return
-			}
-			defer client.Close()
-
-			disconnectRes := new(stubs.Response)
-			err = client.Call(stubs.DisconnectClient, req, disconnectRes)
-			if err != nil {
-				log.Printf("Error calling DisconnectClient on server %s: %v", addr, err)
-			}
-		}(addr)
+// PauseAllServers stops the broker from starting any more turns of the session until UnPauseAllServers is called.
+// It waits for the turn the servers are computing to finish, so every server has
+// stopped after res.Turn.
+func (b *Broker) PauseAllServers(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
 	}
+	s.isPause = true
+	for s.stepping {
+		s.changed.Wait()
+	}
+	res.Turn = s.turn
+	res.Cellnum = s.cellnum
+	fmt.Println("Pause session", s.id, "at turn", s.turn)
+	return nil
+}
 
-	wg.Wait()
-
+func (b *Broker) UnPauseAllServers(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	s.isPause = false
+	s.changed.Broadcast()
+	res.Turn = s.turn
+	fmt.Println("UnPause session", s.id)
 	return nil
 }
-func (b *Broker) AggregateCurrentState(req *stubs.Request, aggregatedRes *stubs.Response) error {
-	var wg sync.WaitGroup
-	partialWorlds := make([][][]uint8, len(b.serverAddrs))
-	var firstTurn int
-	var turnFound bool
 
-	for i, addr := range b.serverAddrs {
-		wg.Add(1)
-		go func(index int, addr string) {
-			defer wg.Done()
-			client, err := rpc.Dial("tcp", addr)
-			if err != nil {
-				log.Printf("Error connecting to server %s: %v", addr, err)
-				return
-			}
-			defer client.Close()
+// startTurn waits until the session is not paused and no snapshot is wanted, and
+// marks it as stepping. Turns run again after a server failed, up to the turn the
+// session had reached, do not wait. It reports whether the session has been killed instead.
+func (b *Broker) startTurn(s *session, turn int) bool {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	for turn > s.turn && !s.killed && (s.wantWorld > 0 || s.fetching || (s.isPause && s.turn >= s.runTo)) {
+		s.changed.Wait()
+	}
+	s.stepping = !s.killed
+	return s.killed
+}
 
-			partRes := new(stubs.Response)
-			err = client.Call(stubs.SendCurrentState, req, partRes)
-			if err != nil {
-				log.Printf("Error calling SendCurrentState on server %s: %v", addr, err)
-				return
-			}
+// currentWorld returns the world of a session and its turn, asking the servers
+// for their strips if the snapshot the broker has is older than the last turn.
+// It waits for the turn being computed to finish. dataLock must be held; it is
+// released while the servers are asked.
+func (b *Broker) currentWorld(s *session) ([][]uint8, int, error) {
+	s.wantWorld++
+	defer func() {
+		s.wantWorld--
+		s.changed.Broadcast()
+	}()
+	for s.stepping || s.fetching {
+		s.changed.Wait()
+	}
+	if s.worldTurn == s.turn || s.clients == nil {
+		return s.world, s.worldTurn, nil
+	}
+	s.fetching = true
+	clients, turn := s.clients, s.turn
+	b.dataLock.Unlock()
+	world, err := fetchWorld(s.id, clients, s.params, turn)
+	b.dataLock.Lock()
+	s.fetching = false
+	if err != nil {
+		return nil, 0, err
+	}
+	s.world, s.worldTurn = world, turn
+	return s.world, s.worldTurn, nil
+}
 
-			b.dataLock.Lock()
-			defer b.dataLock.Unlock()
+// fetchWorld puts the world after turn back together from the strips of the servers.
+func fetchWorld(id int, clients []*rpc.Client, p goUtils.Params, turn int) ([][]uint8, error) {
+	world := make([][]uint8, p.ImageHeight)
+	err := forEachServer(clients, func(i int, client *rpc.Client) error {
+		res := new(stubs.Response)
+		err := client.Call(stubs.SendCurrentState, &stubs.Request{Session: id}, res)
+		if err != nil {
+			return err
+		}
+		if res.Turn != turn || res.StartRow < 0 || res.EndRow > p.ImageHeight || len(res.World) != res.EndRow-res.StartRow {
+			return fmt.Errorf("server %d sent rows %d to %d of turn %d, not turn %d", i, res.StartRow, res.EndRow, res.Turn, turn)
+		}
+		copy(world[res.StartRow:res.EndRow], res.World)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return world, nil
+}
 
-			partialWorlds[index] = partRes.World
-			if !turnFound && partRes.Turn > 0 {
-				firstTurn = partRes.Turn
-				turnFound = true
-			}
-		}(i, addr)
+// AdvanceSession runs the paused session req.Session on to turn req.Turn, or its
+// last turn if that comes first, and returns once it is there.
+func (b *Broker) AdvanceSession(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	if !s.isPause {
+		return fmt.Errorf("session %d is not paused", s.id)
 	}
+	s.runTo = req.Turn
+	if s.runTo > s.params.Turns {
+		s.runTo = s.params.Turns
+	}
+	s.changed.Broadcast()
+	for s.turn < s.runTo && !s.killed && s.err == nil {
+		s.changed.Wait()
+	}
+	if s.err != nil {
+		return s.err
+	}
+	res.Turn = s.turn
+	res.Cellnum = s.cellnum
+	return nil
+}
 
-	wg.Wait()
+// SetTurnDelay makes the broker wait req.Delay after each turn of the session,
+// so the game can be watched turn by turn.
+func (b *Broker) SetTurnDelay(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	s.delay = req.Delay
+	res.Turn = s.turn
+	return nil
+}
 
-	// Aggregate partial worlds
-	var completeWorld [][]uint8
-	for _, part := range partialWorlds {
-		if part != nil {
-			completeWorld = append(completeWorld, part...)
+// ListSessions returns every session on the broker, oldest first.
+func (b *Broker) ListSessions(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	for id := 1; id <= b.lastSession; id++ {
+		s, ok := b.sessions[id]
+		if !ok {
+			continue
 		}
+		finished := false
+		select {
+		case <-s.finished:
+			finished = true
+		default:
+		}
+		res.Sessions = append(res.Sessions, stubs.SessionInfo{
+			ID:       s.id,
+			Params:   s.params,
+			Turn:     s.turn,
+			Attached: s.attached,
+			Paused:   s.isPause,
+			Finished: finished,
+		})
 	}
+	return nil
+}
 
-	aggregatedRes.World = completeWorld
-	aggregatedRes.Turn = firstTurn
+// KillSession stops the session req.Session after the turn being computed and
+// forgets it. Its controller's CallServerProcessWorld or WaitForSession fails.
+func (b *Broker) KillSession(req *stubs.Request, res *stubs.Response) error {
+	if req.Session == 0 {
+		return errors.New("no session given")
+	}
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	b.kill(s)
+	delete(b.sessions, s.id)
+	res.Turn = s.turn
+	fmt.Println("Killed session", s.id)
+	return nil
+}
 
+// GetSession returns the parameters and turn of a session.
+func (b *Broker) GetSession(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	res.Session = s.id
+	res.Params = s.params
+	res.Turn = s.turn
 	return nil
 }
 
-func (b *Broker) AggregateCellNumbers(req *stubs.Request, aggregatedRes *stubs.Response) error {
+// AttachClient makes the caller the controller of a session. It returns the world
+// and its turn; AggregateCellFlip returns the cells that change after that turn.
+func (b *Broker) AttachClient(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	world, turn, err := b.currentWorld(s)
+	if err != nil {
+		return err
+	}
+	s.attached = true
+	s.attaches++
+	s.shown = copyWorld(world)
+	res.Session = s.id
+	res.Params = s.params
+	res.World = copyWorld(world)
+	res.Turn = turn
+	fmt.Println("Client attached to session", s.id, "at turn", turn)
+	return nil
+}
 
-	serverAddr := b.serverAddrs[0]
-	client, err := rpc.Dial("tcp", serverAddr)
+// DetachClient is called by a controller that leaves. The world carries on being
+// processed, but the cells that change are no longer kept until a new controller attaches.
+func (b *Broker) DetachClient(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
 	if err != nil {
-		log.Printf("Error connecting to server %s: %v", serverAddr, err)
 		return err
 	}
-	defer client.Close()
+	s.attached = false
+	s.shown = nil
+	res.Turn = s.turn
+	fmt.Println("Client detached from session", s.id, "at turn", s.turn)
+	return nil
+}
 
-	partRes := new(stubs.Response)
-	err = client.Call(stubs.SendCellNumber, &stubs.Request{}, partRes)
+// WaitForSession waits until a session has finished and returns its world, like
+// CallServerProcessWorld does to the controller that started it.
+func (b *Broker) WaitForSession(req *stubs.Request, res *stubs.Response) error {
+	b.dataLock.Lock()
+	s, err := b.session(req.Session)
+	var controller int
+	if err == nil {
+		controller = s.attaches
+	}
+	b.dataLock.Unlock()
 	if err != nil {
-		log.Printf("Error calling SendCellNumber on server %s: %v", serverAddr, err)
 		return err
 	}
+	<-s.finished
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	res.Session = s.id
+	res.World = copyWorld(s.world)
+	res.Turn = s.turn
+	b.collect(s, controller, res)
+	return nil
+}
 
-	aggregatedRes.Cellnum = partRes.Cellnum
-	aggregatedRes.Turn = partRes.Turn
+// collect gives the last cells that changed in a finished session to its controller
+// and forgets the session, unless the controller left or was taken over from after
+// it had controller attaches. dataLock must be held.
+func (b *Broker) collect(s *session, controller int, res *stubs.Response) {
+	if !s.attached || s.attaches != controller {
+		return
+	}
+	res.StateChanges = cellChanges(s, s.world, s.turn)
+	delete(b.sessions, s.id)
+	fmt.Println("Session", s.id, "is done")
+}
 
-	return nil
+// HungUp kills a session whose controller hung up without detaching, as nobody is
+// left to see it.
+func (b *Broker) HungUp(session int) {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, ok := b.sessions[session]
+	if !ok {
+		return
+	}
+	select {
+	case <-s.finished:
+		// Its controller was being sent the world.
+		return
+	default:
+	}
+	b.kill(s)
+	delete(b.sessions, s.id)
+	fmt.Println("The controller of session", s.id, "hung up")
 }
-func (b *Broker) AggregateCellFlip(req *stubs.Request, aggregatedRes *stubs.Response) error {
 
-	serverAddr := b.serverAddrs[1]
-	client, err := rpc.Dial("tcp", serverAddr)
+// AggregateCurrentState returns the world of a session after the last turn every
+// server completed.
+func (b *Broker) AggregateCurrentState(req *stubs.Request, aggregatedRes *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
 	if err != nil {
-		log.Printf("Error connecting to server %s: %v", serverAddr, err)
 		return err
 	}
-	defer client.Close()
-
-	partRes := new(stubs.Response)
-	err = client.Call(stubs.SendCellFlip, &stubs.Request{}, partRes)
+	world, turn, err := b.currentWorld(s)
 	if err != nil {
-		log.Printf("Error calling SendCellNumber on server %s: %v", serverAddr, err)
 		return err
 	}
+	aggregatedRes.World = copyWorld(world)
+	aggregatedRes.Turn = turn
+	return nil
+}
 
-	aggregatedRes.Turn = partRes.Turn
-	aggregatedRes.StateChanges = partRes.StateChanges
+func (b *Broker) AggregateCellNumbers(req *stubs.Request, aggregatedRes *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	aggregatedRes.Cellnum = s.cellnum
+	aggregatedRes.Turn = s.turn
+	return nil
+}
 
+// AggregateCellFlip returns the cells of a session that changed since it was last
+// called, all with the turn of the world they were taken from. A cell that
+// changed and changed back in between is not included.
+func (b *Broker) AggregateCellFlip(req *stubs.Request, aggregatedRes *stubs.Response) error {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.session(req.Session)
+	if err != nil {
+		return err
+	}
+	world, turn, err := b.currentWorld(s)
+	if err != nil {
+		return err
+	}
+	aggregatedRes.StateChanges = cellChanges(s, world, turn)
+	aggregatedRes.Turn = turn
 	return nil
 }
 
-func (b *Broker) CallServerProcessWorld(req *stubs.Request, aggregatedRes *stubs.Response) error {
-	var wg sync.WaitGroup
+// cellChanges returns the cells of world that changed since the controller of s was
+// last sent it, and keeps world as the one it was sent. dataLock must be held.
+func cellChanges(s *session, world [][]uint8, turn int) []stubs.CellStateChange {
+	var changes []stubs.CellStateChange
+	if s.shown != nil {
+		for y, row := range world {
+			for x, value := range row {
+				if value != s.shown[y][x] {
+					changes = append(changes,
+						stubs.CellStateChange{Cell: util.Cell{X: x, Y: y}, Turn: turn, Previous: s.shown[y][x], Value: value})
+				}
+			}
+		}
+	}
+	s.shown = copyWorld(world)
+	return changes
+}
 
-	partialResults := make([]*stubs.Response, len(b.serverAddrs))
-	for i, addr := range b.serverAddrs {
+// forEachServer calls f for every server at the same time and returns the first error.
+func forEachServer(clients []*rpc.Client, f func(i int, client *rpc.Client) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(clients))
+	for i, client := range clients {
 		wg.Add(1)
-		go func(index int, addr string) {
+		go func(index int, client *rpc.Client) {
 			defer wg.Done()
-			client, err := rpc.Dial("tcp", addr)
-			if err != nil {
-				log.Printf("Error connecting to server %s: %v", addr, err)
-				return
-			}
-			defer client.Close()
-
-			startRow := index * (len(req.World) / len(b.serverAddrs))
-			endRow := startRow + (len(req.World) / len(b.serverAddrs))
-			if index == len(b.serverAddrs)-1 {
-				endRow = len(req.World)
-			} else {
-				endRow = endRow
-			}
+			errs[index] = f(index, client)
+		}(i, client)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			serverReq := &stubs.Request{
-				World:    req.World,
-				Params:   req.Params,
-				StartRow: startRow,
-				EndRow:   endRow,
-			}
+// stripRows returns the rows of server i when height rows are split between servers.
+func stripRows(i, servers, height int) (startRow, endRow int) {
+	return i * height / servers, (i + 1) * height / servers
+}
 
-			partRes := new(stubs.Response)
-			err = client.Call(stubs.Update, serverReq, partRes)
-			if err != nil {
-				log.Printf("Error calling server %s: %v", addr, err)
-			} else {
-				partialResults[index] = partRes
-			}
-		}(i, addr)
+// ping checks that a server answers within heartbeatTimeout.
+func ping(client *rpc.Client) error {
+	call := client.Go(stubs.Ping, &stubs.Request{}, new(stubs.Response), nil)
+	select {
+	case call = <-call.Done:
+		return call.Error
+	case <-time.After(heartbeatTimeout):
+		return errors.New("no answer to heartbeat")
 	}
+}
 
-	wg.Wait()
+// heartbeat pings the servers until stop is closed. The connection to a server
+// that does not answer is closed, so that a turn waiting on it fails at once.
+func heartbeat(addrs []string, clients []*rpc.Client, stop <-chan bool) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	closed := make([]bool, len(clients))
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			forEachServer(clients, func(i int, client *rpc.Client) error {
+				if closed[i] {
+					return nil
+				}
+				err := ping(client)
+				if err != nil {
+					log.Printf("Server %s missed a heartbeat: %v", addrs[i], err)
+					client.Close()
+					closed[i] = true
+				}
+				return nil
+			})
+		}
+	}
+}
 
-	var aggregatedWorld [][]uint8
-	for _, partRes := range partialResults {
-		if partRes != nil {
+// checkServers pings every server on a new connection and splits them into
+// those that still answer and those that have failed.
+func checkServers(addrs []string) (alive, failed []string) {
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, heartbeatTimeout)
+		if err == nil {
+			client := rpc.NewClient(conn)
+			err = ping(client)
+			client.Close()
+		}
+		if err != nil {
+			log.Printf("Server %s has failed: %v", addr, err)
+			failed = append(failed, addr)
+		} else {
+			alive = append(alive, addr)
+		}
+	}
+	return alive, failed
+}
 
-			aggregatedWorld = append(aggregatedWorld, partRes.World...)
+// dropServers removes failed servers from the pool.
+func (b *Broker) dropServers(failed []string) {
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	for _, addr := range failed {
+		for i := range b.serverAddrs {
+			if b.serverAddrs[i] == addr {
+				b.serverAddrs = append(b.serverAddrs[:i], b.serverAddrs[i+1:]...)
+				break
+			}
 		}
 	}
+}
 
-	aggregatedRes.World = aggregatedWorld
-	aggregatedRes.StateChanges = partialResults[0].StateChanges
-	//fmt.Println(partialResults[0].StateChanges)
-	for _, partRes := range partialResults {
-		if partRes != nil {
-			aggregatedRes.Turn = partRes.Turn
+// loadStrips connects to the servers and gives each one a strip of the session's
+// world as it was after turn. The servers are told who owns the rows next to their strip.
+func loadStrips(id int, addrs []string, world [][]uint8, p goUtils.Params, turn int) ([]*rpc.Client, error) {
+	servers := len(addrs)
+	clients := make([]*rpc.Client, servers)
+	for i := range clients {
+		client, err := rpc.Dial("tcp", addrs[i])
+		if err != nil {
+			log.Printf("Error connecting to server %s: %v", addrs[i], err)
+			closeClients(clients)
+			return nil, err
+		}
+		clients[i] = client
+	}
 
-			break
+	err := forEachServer(clients, func(i int, client *rpc.Client) error {
+		startRow, endRow := stripRows(i, servers, p.ImageHeight)
+		serverReq := &stubs.Request{
+			Session:   id,
+			World:     world[startRow:endRow],
+			Params:    p,
+			StartRow:  startRow,
+			EndRow:    endRow,
+			Turn:      turn,
+			AboveAddr: addrs[(i-1+servers)%servers],
+			BelowAddr: addrs[(i+1)%servers],
 		}
+		return client.Call(stubs.LoadStrip, serverReq, new(stubs.Response))
+	})
+	if err != nil {
+		log.Printf("Error loading strips: %v", err)
+		closeClients(clients)
+		return nil, err
 	}
-	return nil
+	return clients, nil
 }
 
-func (b *Broker) LoadWorldToBroker(req *stubs.Request, res *stubs.Response) (err error) {
-	fmt.Println("LoadWorldToBroker")
+// edgeColumns returns the left and right columns of the world on the projective
+// plane, and nil everywhere else.
+func edgeColumns(world [][]uint8, p goUtils.Params, topology util.Topology) (left, right []uint8) {
+	if topology != util.Projective {
+		return nil, nil
+	}
+	left = make([]uint8, p.ImageHeight)
+	right = make([]uint8, p.ImageHeight)
+	for y, row := range world {
+		left[y] = row[0]
+		right[y] = row[p.ImageWidth-1]
+	}
+	return left, right
+}
 
-	if req == nil {
-		err = errors.New("Empty data")
-		return
+// CallServerProcessWorld processes the world of session req.Session, loaded with
+// LoadWorldToBroker, or of a new session made from req if it is 0. The caller
+// becomes the session's controller. The world keeps going if the controller
+// detaches, and another controller can take over with AttachClient and WaitForSession.
+// The session is forgotten once it fails or its controller has the final world,
+// and killed if the controller hangs up without detaching.
+func (b *Broker) CallServerProcessWorld(req *stubs.Request, aggregatedRes *stubs.Response) error {
+	b.dataLock.Lock()
+	var s *session
+	var err error
+	if req.Session == 0 {
+		s, err = b.newSession(req.Params, req.World, req.Turn)
+	} else {
+		s, err = b.session(req.Session)
+	}
+	if err != nil {
+		b.dataLock.Unlock()
+		return err
+	}
+	if s.started {
+		b.dataLock.Unlock()
+		return fmt.Errorf("session %d has already been started", s.id)
 	}
+	s.started = true
+	s.attached = true
+	s.shown = copyWorld(s.world)
+	controller := s.attaches
+	b.dataLock.Unlock()
 
-	fmt.Println("Load World data...")
+	err = b.processWorld(s)
+	b.dataLock.Lock()
+	s.err = err
+	s.changed.Broadcast()
+	aggregatedRes.Session = s.id
+	aggregatedRes.World = copyWorld(s.world)
+	aggregatedRes.Turn = s.turn
+	if err != nil {
+		delete(b.sessions, s.id)
+	} else {
+		b.collect(s, controller, aggregatedRes)
+	}
+	b.dataLock.Unlock()
+	close(s.finished)
+	return err
+}
 
+// snapshotInterval is the longest the broker goes without a snapshot of a world
+// while it is processed, and so the most work lost when a server fails.
+const snapshotInterval = 2 * time.Second
+
+// processWorld splits the world of a session into one strip of rows per server
+// and runs the rest of the turns. Every turn each server computes only its own
+// strip after swapping edge rows directly with the servers above and below it;
+// the broker only starts the turns, adds up the alive cells and, on the
+// projective plane, passes on the edge columns. The world is only sent to the
+// broker for a snapshot.
+//
+// If a turn or snapshot fails because a server died or stopped answering its
+// heartbeats, the failed servers are dropped and the last snapshot is split
+// again between the ones left. The turns after the snapshot are run again
+// before the session moves on, without being seen by the controller.
+func (b *Broker) processWorld(s *session) error {
 	b.dataLock.Lock()
-	b.world = req.World
+	p := s.params
+	world := s.world
+	startTurn := s.turn
+	b.dataLock.Unlock()
+
+	topology, err := util.ParseTopology(p.Topology)
+	if err != nil {
+		return err
+	}
+	if startTurn < 0 || startTurn > p.Turns {
+		return fmt.Errorf("cannot start at turn %d of %d", startTurn, p.Turns)
+	}
+	serverAddrs := b.servers()
+	if len(serverAddrs) == 0 {
+		return errors.New("no servers registered")
+	}
+	if len(serverAddrs) > p.ImageHeight {
+		serverAddrs = serverAddrs[:p.ImageHeight]
+	}
 
-	b.params = req.Params
-	b.turn = 0
+	clients, err := loadStrips(s.id, serverAddrs, world, p, startTurn)
+	if err != nil {
+		return err
+	}
+	b.dataLock.Lock()
+	s.clients = clients
 	b.dataLock.Unlock()
+	stop := make(chan bool)
+	go heartbeat(serverAddrs, clients, stop)
+	defer func() {
+		b.dataLock.Lock()
+		s.stepping = false
+		s.clients = nil
+		s.changed.Broadcast()
+		b.dataLock.Unlock()
+		close(stop)
+		// The servers can forget their strips; a server that failed does not matter here.
+		forEachServer(clients, func(i int, client *rpc.Client) error {
+			return client.Call(stubs.EndSession, &stubs.Request{Session: s.id}, new(stubs.Response))
+		})
+		closeClients(clients)
+	}()
+	left, right := edgeColumns(world, p, topology)
+	snapshotAt := time.Now()
+
+	for turn := startTurn + 1; turn <= p.Turns; {
+		if b.startTurn(s, turn) {
+			return fmt.Errorf("session %d was killed", s.id)
+		}
+		responses := make([]*stubs.Response, len(clients))
+		err = forEachServer(clients, func(i int, client *rpc.Client) error {
+			stepReq := &stubs.Request{
+				Session:     s.id,
+				Turn:        turn,
+				LeftColumn:  left,
+				RightColumn: right,
+			}
+			responses[i] = new(stubs.Response)
+			return client.Call(stubs.Step, stepReq, responses[i])
+		})
+		if err == nil {
+			cellnum := 0
+			for i, partRes := range responses {
+				if left != nil {
+					startRow, endRow := stripRows(i, len(clients), p.ImageHeight)
+					copy(left[startRow:endRow], partRes.LeftColumn)
+					copy(right[startRow:endRow], partRes.RightColumn)
+				}
+				cellnum += partRes.Cellnum
+			}
+			b.dataLock.Lock()
+			caughtUp := turn >= s.turn
+			if caughtUp {
+				s.turn = turn
+				s.cellnum = cellnum
+				s.stepping = false
+				s.changed.Broadcast()
+			}
+			delay := s.delay
+			// The last turn is always kept, as the servers forget the world once it is done.
+			if caughtUp && (turn == p.Turns || time.Since(snapshotAt) >= snapshotInterval) {
+				_, _, err = b.currentWorld(s)
+				snapshotAt = time.Now()
+			}
+			b.dataLock.Unlock()
+			if err == nil {
+				turn++
+				if caughtUp && delay > 0 {
+					time.Sleep(delay)
+				}
+				continue
+			}
+		}
 
-	return
+		log.Printf("Error computing turn %d of session %d: %v", turn, s.id, err)
+		alive, failed := checkServers(serverAddrs)
+		if len(failed) == 0 || len(alive) == 0 {
+			return err
+		}
+		b.dropServers(failed)
+		close(stop)
+		closeClients(clients)
+		stop = make(chan bool)
+		serverAddrs = alive
+		// The snapshot and its turn are taken together; until the turns after it
+		// have been run again the session stays stepping, so no other snapshot is taken.
+		b.dataLock.Lock()
+		world, from := s.world, s.worldTurn
+		s.clients = nil
+		s.stepping = true
+		b.dataLock.Unlock()
+		clients, err = loadStrips(s.id, serverAddrs, world, p, from)
+		if err != nil {
+			clients = nil
+			return err
+		}
+		b.dataLock.Lock()
+		s.clients = clients
+		b.dataLock.Unlock()
+		go heartbeat(serverAddrs, clients, stop)
+		left, right = edgeColumns(world, p, topology)
+		turn = from + 1
+		fmt.Printf("Carrying on session %d from turn %d with %d servers\n", s.id, from, len(serverAddrs))
+	}
+	return nil
+}
+
+func closeClients(clients []*rpc.Client) {
+	for _, client := range clients {
+		if client != nil {
+			client.Close()
+		}
+	}
+}
 
+func copyWorld(world [][]uint8) [][]uint8 {
+	newWorld := make([][]uint8, len(world))
+	for y, row := range world {
+		newWorld[y] = append([]uint8(nil), row...)
+	}
+	return newWorld
 }
 
-func main() {
-	pAddr := flag.String("port", "8034", "Port to listen on")
-	//serverAddr1 := flag.String("serverAddr1", "127.0.0.1:8035", "Server address")
-	//serverAddr2 := flag.String("serverAddr2", "127.0.0.1:8036", "Server address")
-	serverAddr1 := flag.String("serverAddr1", "54.90.175.225:8036", "Server address")
-	serverAddr2 := flag.String("serverAddr2", "52.90.222.177:8035", "Server address")
-	flag.Parse()
-	flag.Parse()
-	worker := &Broker{
-		serverAddrs: []string{*serverAddr1, *serverAddr2},
+func countCell(world [][]uint8) int {
+	counter := 0
+	for _, row := range world {
+		for _, value := range row {
+			if value == 255 {
+				counter++
+			}
+		}
 	}
-	err := rpc.Register(worker)
-	if err != nil {
-		log.Fatalf("Error registering service: %v", err)
+	return counter
+}
+
+// LoadWorldToBroker starts a new session with the world in req, which is from
+// turn req.Turn, and returns its ID for CallServerProcessWorld.
+func (b *Broker) LoadWorldToBroker(req *stubs.Request, res *stubs.Response) (err error) {
+	fmt.Println("LoadWorldToBroker")
+
+	if req == nil {
+		err = errors.New("Empty data")
+		return
 	}
-	listener, err := net.Listen("tcp", ":"+*pAddr)
+
+	fmt.Println("Load World data...")
+
+	b.dataLock.Lock()
+	defer b.dataLock.Unlock()
+	s, err := b.newSession(req.Params, req.World, req.Turn)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return
 	}
-	fmt.Println("listening on %s", listener.Addr().String())
-	defer listener.Close()
-	rpc.Accept(listener)
+	res.Session = s.id
+	return
 
 }
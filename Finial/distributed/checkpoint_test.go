@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCheckpoint runs the 512x512 image to turn 100 on a broker on port 8080 with 2 servers, resuming
+// from a checkpoint of turn 1 and from the last checkpoint saved while running every 10ms.
+func TestCheckpoint(t *testing.T) {
+	brokerAddr, stop := startProcessCluster(t, 8080, 2)
+	defer stop()
+	dir, err := ioutil.TempDir("", "gol-checkpoint")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 100, BrokerAddr: brokerAddr}
+	expectedAlive := readAliveCells("check/images/512x512x100.pgm", 512, 512)
+
+	t.Run("first", func(t *testing.T) {
+		p := p
+		p.Resume = filepath.Join(dir, "first.gol")
+		util.Check(gol.WriteCheckpoint(p.Resume, gol.Checkpoint{
+			Params: p,
+			Turn:   1,
+			World:  readCellValues("check/images/512x512x1.pgm", 512, 512),
+		}))
+		assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+	})
+
+	t.Run("saved", func(t *testing.T) {
+		p := p
+		saved := filepath.Join(dir, "saved.gol")
+		p.Checkpoint, p.CheckpointEvery = saved, 10*time.Millisecond
+		runToFinalTurn(p)
+		_, err := gol.ReadCheckpoint(saved)
+		if err != nil {
+			t.Fatalf("No checkpoint was saved: %v", err)
+		}
+		p.Checkpoint, p.Resume = "", saved
+		assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+	})
+
+	t.Run("broken", func(t *testing.T) {
+		header := "GOL-CHECKPOINT 1\nturns 10\nturn 5\nrule B3/S23\ntopology torus\n"
+		tests := map[string]string{
+			"an unknown version": "GOL-CHECKPOINT 2\nwidth 1\nheight 1\nworld\n\x00",
+			"a huge size":        header + "width 1000000000\nheight 1000000000\nworld\n\x00",
+			"a short world":      header + "width 2\nheight 2\nworld\n\x00\xff\x00",
+			"a long world":       header + "width 2\nheight 2\nworld\n\x00\xff\x00\xff\x00",
+		}
+		for name, text := range tests {
+			path := filepath.Join(dir, "broken.gol")
+			util.Check(ioutil.WriteFile(path, []byte(text), 0644))
+			_, err := gol.ReadCheckpoint(path)
+			if err == nil {
+				t.Errorf("Expected a checkpoint with %s to be rejected", name)
+			}
+		}
+		path := filepath.Join(dir, "good.gol")
+		util.Check(ioutil.WriteFile(path, []byte(header+"width 2\nheight 2\nworld\n\x00\xff\x00\xff"), 0644))
+		if _, err := gol.ReadCheckpoint(path); err != nil {
+			t.Errorf("Expected a 2x2 checkpoint to be read, got %v", err)
+		}
+	})
+}
+
+func runToFinalTurn(p gol.Params) []util.Cell {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var cells []util.Cell
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	return cells
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/broker"
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/server"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestServerPool starts a broker with no servers on port 8060 and runs the 64x64 image for
+// 100 turns with 1, 2, 5 and 16 servers that join and leave the pool between runs.
+func TestServerPool(t *testing.T) {
+	dir := buildCluster(t)
+	defer os.RemoveAll(dir)
+	brokerAddr := "127.0.0.1:8060"
+	broker := startProcess(t, brokerAddr, filepath.Join(dir, "broker"), "-port", "8060")
+	defer func() {
+		broker.Process.Kill()
+		broker.Wait()
+	}()
+	client, err := rpc.Dial("tcp", brokerAddr)
+	util.Check(err)
+	defer client.Close()
+
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100, BrokerAddr: brokerAddr}
+	expectedAlive := readAliveCells(
+		"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns),
+		p.ImageWidth,
+		p.ImageHeight,
+	)
+	for _, servers := range []int{1, 2, 5, 16} {
+		var processes []*exec.Cmd
+		for i := 0; i < servers; i++ {
+			port := strconv.Itoa(8061 + i)
+			addr := "127.0.0.1:" + port
+			processes = append(processes, startProcess(t, addr, filepath.Join(dir, "server"), "-port", port, "-broker", brokerAddr))
+			// The server registers itself; registering again does nothing but makes sure it has joined before the run.
+			err := client.Call(stubs.RegisterServer, &stubs.Request{ServerAddr: addr}, new(stubs.Response))
+			util.Check(err)
+		}
+
+		testName := fmt.Sprintf("%dx%dx%d-%dservers", p.ImageWidth, p.ImageHeight, p.Turns, servers)
+		t.Run(testName, func(t *testing.T) {
+			events := make(chan gol.Event)
+			go gol.Run(p, events, nil)
+			var cells []util.Cell
+			for event := range events {
+				switch e := event.(type) {
+				case gol.FinalTurnComplete:
+					cells = e.Alive
+				}
+			}
+			assertEqualBoard(t, cells, expectedAlive, p)
+		})
+
+		// Interrupted servers deregister before they exit, so the next run only uses the new ones.
+		for _, process := range processes {
+			process.Process.Signal(os.Interrupt)
+			process.Wait()
+		}
+	}
+}
+
+// TestFaultTolerance runs the 512x512 image for 100 turns on a broker on port 8070 with 4 servers and
+// kills one server after turn 10 and another after turn 50. The two left have to finish the game.
+func TestFaultTolerance(t *testing.T) {
+	dir := buildCluster(t)
+	defer os.RemoveAll(dir)
+	var servers []*exec.Cmd
+	var addrs []string
+	for i := 0; i < 4; i++ {
+		port := strconv.Itoa(8071 + i)
+		addr := "127.0.0.1:" + port
+		servers = append(servers, startProcess(t, addr, filepath.Join(dir, "server"), "-port", port))
+		addrs = append(addrs, addr)
+	}
+	defer func() {
+		for _, server := range servers {
+			server.Process.Kill()
+			server.Wait()
+		}
+	}()
+	brokerAddr := "127.0.0.1:8070"
+	broker := startProcess(t, brokerAddr, filepath.Join(dir, "broker"), "-port", "8070", "-servers", strings.Join(addrs, ","))
+	defer func() {
+		broker.Process.Kill()
+		broker.Wait()
+	}()
+	client, err := rpc.Dial("tcp", brokerAddr)
+	util.Check(err)
+	defer client.Close()
+
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 100, BrokerAddr: brokerAddr}
+	expectedAlive := readAliveCells(
+		"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns),
+		p.ImageWidth,
+		p.ImageHeight,
+	)
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+
+	// Kill a server once the broker has reached each turn, checking every 10ms.
+	done := make(chan bool)
+	go func() {
+		for _, kill := range []struct{ turn, server int }{{10, 1}, {50, 2}} {
+			for {
+				res := new(stubs.Response)
+				if client.Call(stubs.AggregateCellNumbers, &stubs.Request{}, res) == nil && res.Turn >= kill.turn {
+					if res.Turn == p.Turns {
+						t.Errorf("The game finished before a server could be killed after turn %d", kill.turn)
+						close(done)
+						return
+					}
+					servers[kill.server].Process.Kill()
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		close(done)
+	}()
+
+	var cells []util.Cell
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	<-done
+	assertEqualBoard(t, cells, expectedAlive, p)
+}
+
+// TestFaultToleranceMidTurn runs a random 512x512 world with rule B36/S23 for 500 turns on a broker
+// on port 8190 with 5 servers, and kills 3 of them while turns are being computed, when halo rows
+// of the failed turn are still on their way. The result has to be the same as a run of the world
+// on a single server, with a broker on port 8180.
+func TestFaultToleranceMidTurn(t *testing.T) {
+	dir := buildCluster(t)
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "random.pgm")
+	random := rand.New(rand.NewSource(14))
+	image := []byte("P5 512 512 255\n")
+	for i := 0; i < 512*512; i++ {
+		if random.Intn(3) == 0 {
+			image = append(image, 255)
+		} else {
+			image = append(image, 0)
+		}
+	}
+	util.Check(ioutil.WriteFile(input, image, 0644))
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 500, Rule: "B36/S23", InputPath: input, OutputDir: dir}
+
+	// The single server run, in this process.
+	localAddr, stop := startLocalCluster(8180, 1)
+	p.BrokerAddr = localAddr
+	expected := runToFinalTurn(p)
+	stop()
+
+	var servers []*exec.Cmd
+	var addrs []string
+	for i := 0; i < 5; i++ {
+		port := strconv.Itoa(8191 + i)
+		addr := "127.0.0.1:" + port
+		servers = append(servers, startProcess(t, addr, filepath.Join(dir, "server"), "-port", port))
+		addrs = append(addrs, addr)
+	}
+	defer func() {
+		for _, process := range servers {
+			process.Process.Kill()
+			process.Wait()
+		}
+	}()
+	brokerAddr := "127.0.0.1:8190"
+	brokerProcess := startProcess(t, brokerAddr, filepath.Join(dir, "broker"), "-port", "8190", "-servers", strings.Join(addrs, ","))
+	defer func() {
+		brokerProcess.Process.Kill()
+		brokerProcess.Wait()
+	}()
+	client, err := rpc.Dial("tcp", brokerAddr)
+	util.Check(err)
+	defer client.Close()
+
+	p.BrokerAddr = brokerAddr
+	// The broker starts the next turn as soon as one is done, so a server killed
+	// once a turn is reached is killed in the middle of the turn after it.
+	finished := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for _, kill := range []struct{ turn, server int }{{50, 1}, {150, 3}, {300, 0}} {
+			for {
+				res := new(stubs.Response)
+				call := client.Go(stubs.AggregateCellNumbers, &stubs.Request{}, res, nil)
+				select {
+				case <-call.Done:
+				case <-finished:
+					t.Errorf("The game finished before a server could be killed after turn %d", kill.turn)
+					return
+				}
+				if call.Error == nil && res.Turn >= kill.turn {
+					if res.Turn == p.Turns {
+						t.Errorf("The game finished before a server could be killed after turn %d", kill.turn)
+						return
+					}
+					servers[kill.server].Process.Kill()
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	cells := runToFinalTurn(p)
+	close(finished)
+	<-done
+	assertEqualBoard(t, cells, expected, p)
+}
+
+// startLocalCluster serves a broker on port and servers on the ports after it in this process.
+// It returns the broker's address and a func that stops them all and returns the first error
+// they stopped with.
+func startLocalCluster(port, servers int) (string, func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, servers+1)
+	var serverAddrs []string
+	for i := 1; i <= servers; i++ {
+		addr := "127.0.0.1:" + strconv.Itoa(port+i)
+		listener, err := net.Listen("tcp", addr)
+		util.Check(err)
+		go func() {
+			stopped <- server.Serve(ctx, listener)
+		}()
+		serverAddrs = append(serverAddrs, addr)
+	}
+	brokerAddr := "127.0.0.1:" + strconv.Itoa(port)
+	listener, err := net.Listen("tcp", brokerAddr)
+	util.Check(err)
+	go func() {
+		stopped <- broker.Serve(ctx, listener, serverAddrs)
+	}()
+	return brokerAddr, func() error {
+		cancel()
+		var first error
+		for i := 0; i <= servers; i++ {
+			if err := <-stopped; err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+}
+
+// startProcessCluster builds the broker and server and runs them as processes, the broker on
+// port and servers on the ports after it. It returns the broker's address and a func that kills
+// the processes and removes the build.
+func startProcessCluster(tb testing.TB, port, servers int) (string, func()) {
+	dir := buildCluster(tb)
+	var processes []*exec.Cmd
+	stop := func() {
+		for _, process := range processes {
+			process.Process.Kill()
+			process.Wait()
+		}
+		os.RemoveAll(dir)
+	}
+	started := false
+	defer func() {
+		if !started {
+			stop()
+		}
+	}()
+	var serverAddrs []string
+	for i := 1; i <= servers; i++ {
+		addr := "127.0.0.1:" + strconv.Itoa(port+i)
+		processes = append(processes, startProcess(tb, addr, filepath.Join(dir, "server"), "-port", strconv.Itoa(port+i)))
+		serverAddrs = append(serverAddrs, addr)
+	}
+	brokerAddr := "127.0.0.1:" + strconv.Itoa(port)
+	processes = append(processes, startProcess(tb, brokerAddr, filepath.Join(dir, "broker"),
+		"-port", strconv.Itoa(port), "-servers", strings.Join(serverAddrs, ",")))
+	started = true
+	return brokerAddr, stop
+}
+
+// buildCluster builds the server and broker into a new temporary directory and returns it.
+func buildCluster(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "gol-cluster")
+	util.Check(err)
+	for _, program := range []string{"server", "broker"} {
+		output, err := exec.Command("go", "build", "-o", filepath.Join(dir, program), "./cmd/"+program).CombinedOutput()
+		if err != nil {
+			os.RemoveAll(dir)
+			tb.Fatalf("Building %s failed: %v\n%s", program, err, output)
+		}
+	}
+	return dir
+}
+
+// startProcess runs a program and waits until it accepts connections on addr.
+func startProcess(tb testing.TB, addr, program string, args ...string) *exec.Cmd {
+	cmd := exec.Command(program, args...)
+	util.Check(cmd.Start())
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return cmd
+		}
+	}
+	cmd.Process.Kill()
+	tb.Fatalf("%s did not start listening on %s", program, addr)
+	return nil
+}
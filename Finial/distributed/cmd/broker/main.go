@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"uk.ac.bris.cs/gameoflife/broker"
+)
+
+// readServerList reads server addresses from a file, one per line. Blank lines
+// and lines starting with # are skipped.
+func readServerList(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			addrs = append(addrs, line)
+		}
+	}
+	return addrs, nil
+}
+
+func main() {
+	pAddr := flag.String("port", "8034", "Port to listen on")
+	servers := flag.String("servers", "", "Comma-separated server addresses, e.g. 127.0.0.1:8035,127.0.0.1:8036")
+	config := flag.String("config", "", "File with one server address per line")
+	flag.Parse()
+	// More servers can join later with RegisterServer.
+	var serverAddrs []string
+	if *servers != "" {
+		serverAddrs = strings.Split(*servers, ",")
+	}
+	if *config != "" {
+		addrs, err := readServerList(*config)
+		if err != nil {
+			log.Fatalf("Error reading server list: %v", err)
+		}
+		serverAddrs = append(serverAddrs, addrs...)
+	}
+	listener, err := net.Listen("tcp", ":"+*pAddr)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	fmt.Println("listening on", listener.Addr().String())
+
+	// An interrupt stops the broker the same way ShutDownAllServers does, but leaves the servers running.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	err = broker.Serve(ctx, listener, serverAddrs)
+	if err != nil {
+		log.Printf("Error serving: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"uk.ac.bris.cs/gameoflife/server"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// joinBroker registers the server with the broker. The returned function deregisters it.
+func joinBroker(brokerAddr, serverAddr string) func() {
+	client, err := rpc.Dial("tcp", brokerAddr)
+	if err != nil {
+		log.Fatalf("Error connecting to broker %s: %v", brokerAddr, err)
+	}
+	req := &stubs.Request{ServerAddr: serverAddr}
+	err = client.Call(stubs.RegisterServer, req, new(stubs.Response))
+	if err != nil {
+		log.Fatalf("Error registering with broker %s: %v", brokerAddr, err)
+	}
+	return func() {
+		err := client.Call(stubs.DeregisterServer, req, new(stubs.Response))
+		if err != nil {
+			log.Printf("Error deregistering from broker %s: %v", brokerAddr, err)
+		}
+		client.Close()
+	}
+}
+
+func main() {
+	pAddr := flag.String("port", "8036", "Port to listen on")
+	brokerAddr := flag.String("broker", "", "Broker to register with, e.g. 127.0.0.1:8034")
+	serverAddr := flag.String("addr", "", "Address the broker and other servers reach this server on. Defaults to 127.0.0.1:<port>")
+	flag.Parse()
+	listener, err := net.Listen("tcp", ":"+*pAddr)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	fmt.Println("listening on", listener.Addr().String())
+	if *brokerAddr != "" {
+		if *serverAddr == "" {
+			*serverAddr = "127.0.0.1:" + *pAddr
+		}
+		leave := joinBroker(*brokerAddr, *serverAddr)
+		defer leave()
+	}
+
+	// An interrupt stops the server the same way the broker's ShotDown does.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	err = server.Serve(ctx, listener)
+	if err != nil {
+		log.Printf("Error serving: %v", err)
+	}
+}
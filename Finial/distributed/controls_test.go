@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestControls pauses the 64x64 image on a broker on port 8130 with 2 servers, steps three turns
+// with n, fast-forwards 50 more with f and resumes it throttled with -. The alive cells have to be
+// right after every turn, and the throttled game has to run at most a turn every 40ms. Typing f,
+// a turn and p resumes the game without fast-forwarding, and fast-forwarding to the final turn
+// ends the game.
+func TestControls(t *testing.T) {
+	brokerAddr, stop := startLocalCluster(8130, 2)
+	defer stop()
+
+	t.Run("step, fast-forward and throttle", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 10000000000, BrokerAddr: brokerAddr}
+		alive := readAliveCounts(p.ImageWidth, p.ImageHeight)
+		events := make(chan gol.Event)
+		keyPresses := make(chan rune, 20)
+		go gol.Run(p, events, keyPresses)
+		keyPresses <- 'p'
+
+		board := make([][]bool, p.ImageHeight)
+		for y := range board {
+			board[y] = make([]bool, p.ImageWidth)
+		}
+		count := 0
+		flip := func(cell util.Cell) {
+			board[cell.Y][cell.X] = !board[cell.Y][cell.X]
+			if board[cell.Y][cell.X] {
+				count++
+			} else {
+				count--
+			}
+		}
+
+		paused, target := -1, -1
+		var steps []int
+		resumedTurn := -1
+		quit := false
+		for event := range events {
+			switch e := event.(type) {
+			case gol.CellFlipped:
+				flip(e.Cell)
+			case gol.CellsFlipped:
+				for _, cell := range e.Cells {
+					flip(cell)
+				}
+			case gol.TurnComplete:
+				if e.CompletedTurns <= 10000 && count != alive[e.CompletedTurns] {
+					t.Errorf("Incorrect number of alive cells on turn %d. Was %d, should be %d.", e.CompletedTurns, count, alive[e.CompletedTurns])
+				}
+				if paused >= 0 && resumedTurn == -1 {
+					steps = append(steps, e.CompletedTurns)
+				}
+				// The controller reports the turn every 2 seconds.
+				if resumedTurn >= 0 && !quit {
+					if e.CompletedTurns-resumedTurn > 60 {
+						t.Errorf("Expected at most 50 turns in 2s after throttling, got %d", e.CompletedTurns-resumedTurn)
+					}
+					keyPresses <- 'q'
+					quit = true
+				}
+			case gol.StateChange:
+				switch e.NewState {
+				case gol.Paused:
+					paused = e.CompletedTurns
+					target = paused + 53
+					keyPresses <- 'n'
+					keyPresses <- 'n'
+					keyPresses <- 'n'
+					keyPresses <- 'f'
+					for _, digit := range strconv.Itoa(target) {
+						keyPresses <- digit
+					}
+					keyPresses <- '\n'
+					keyPresses <- '-'
+					keyPresses <- '-'
+					keyPresses <- '-'
+					keyPresses <- 'p'
+				case gol.Executing:
+					if e.CompletedTurns != target {
+						t.Errorf("Expected to resume at turn %d, resumed at turn %d", target, e.CompletedTurns)
+					}
+					resumedTurn = e.CompletedTurns
+				}
+			}
+		}
+		// Fast-forwarding sends the cells of all its turns with one TurnComplete.
+		if len(steps) != 4 || steps[0] != paused+1 || steps[2] != paused+3 || steps[3] != target {
+			t.Errorf("Expected turns %d, %d, %d and %d while paused, got %v", paused+1, paused+2, paused+3, target, steps)
+		}
+	})
+
+	// A key other than Enter cancels typing a turn after f, and is not lost.
+	t.Run("cancel fast-forward", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 10000000000, BrokerAddr: brokerAddr}
+		events := make(chan gol.Event)
+		keyPresses := make(chan rune, 20)
+		go gol.Run(p, events, keyPresses)
+		keyPresses <- 'p'
+		paused := -1
+		for event := range events {
+			e, ok := event.(gol.StateChange)
+			if !ok {
+				continue
+			}
+			switch e.NewState {
+			case gol.Paused:
+				paused = e.CompletedTurns
+				keyPresses <- 'f'
+				keyPresses <- '1'
+				keyPresses <- '0'
+				keyPresses <- 'p'
+			case gol.Executing:
+				if e.CompletedTurns != paused {
+					t.Errorf("Expected to resume at turn %d without fast-forwarding, resumed at turn %d", paused, e.CompletedTurns)
+				}
+				keyPresses <- 'q'
+			}
+		}
+		if paused == -1 {
+			t.Errorf("Expected the game to pause")
+		}
+	})
+
+	// Fast-forwarding to the final turn while paused ends the game without pressing p again.
+	t.Run("fast-forward to the end", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 2000, BrokerAddr: brokerAddr}
+		alive := readAliveCounts(p.ImageWidth, p.ImageHeight)
+		events := make(chan gol.Event)
+		keyPresses := make(chan rune, 20)
+		go gol.Run(p, events, keyPresses)
+		keyPresses <- 'p'
+		timeout := time.After(30 * time.Second)
+		final := -1
+		for final == -1 {
+			select {
+			case event := <-events:
+				switch e := event.(type) {
+				case gol.StateChange:
+					if e.NewState == gol.Paused {
+						keyPresses <- 'f'
+						for _, digit := range strconv.Itoa(p.Turns) {
+							keyPresses <- digit
+						}
+						keyPresses <- '\n'
+					}
+				case gol.FinalTurnComplete:
+					final = e.CompletedTurns
+					if len(e.Alive) != alive[final] {
+						t.Errorf("Expected %d alive cells after turn %d, got %d", alive[final], final, len(e.Alive))
+					}
+				}
+			case <-timeout:
+				t.Fatalf("The game did not finish after fast-forwarding to turn %d", p.Turns)
+			}
+		}
+		if final != p.Turns {
+			t.Errorf("Expected the final turn %d, got %d", p.Turns, final)
+		}
+		for range events {
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// gliders holds the same glider in every pattern format.
+var gliders = map[gol.PatternFormat]string{
+	gol.FormatRLE:     gliderRLE,
+	gol.FormatCells:   "!Name: Glider\n!The smallest spaceship.\n.O.\n..O\nOOO\n",
+	gol.FormatLife105: "#Life 1.05\n#D The smallest spaceship.\n#N\n#P -1 -1\n.*\n#P 1 0\n*\n#P -1 1\n***\n",
+	gol.FormatLife106: "#Life 1.06\n0 -1\n1 0\n-1 1\n0 1\n1 1\n",
+}
+
+// TestPatternFormats reads a glider in every format, finding the format from the header, rejects
+// a plaintext pattern that is too large and runs the Life 1.06 glider on a broker on port 8150
+// with 2 servers. The final world has to be saved as a Life 1.06 pattern too.
+func TestPatternFormats(t *testing.T) {
+	expectedCells := [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+	for format, text := range gliders {
+		pattern, detected, err := gol.ReadPattern(strings.NewReader(text), gol.FormatAuto)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if detected != format {
+			t.Errorf("Expected the format to be detected as %s, got %s", format, detected)
+		}
+		if !reflect.DeepEqual(pattern.Cells, expectedCells) {
+			t.Errorf("%s: expected the glider's cells %v, got %v", format, expectedCells, pattern.Cells)
+		}
+	}
+	_, _, err := gol.ReadPattern(strings.NewReader(strings.Repeat("O\n", 70000)), gol.FormatCells)
+	if err == nil {
+		t.Errorf("Expected a plaintext pattern of 70000 rows to be rejected")
+	}
+
+	brokerAddr, stop := startLocalCluster(8150, 2)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "gol-formats")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "glider.lif")
+	util.Check(ioutil.WriteFile(path, []byte(gliders[gol.FormatLife106]), 0644))
+
+	p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 8, BrokerAddr: brokerAddr, Pattern: path, PatternAt: &util.Cell{X: 2, Y: 1}}
+	expected := []util.Cell{{X: 5, Y: 3}, {X: 6, Y: 4}, {X: 4, Y: 5}, {X: 5, Y: 5}, {X: 6, Y: 5}}
+	assertEqualBoard(t, runToFinalTurn(p), expected, p)
+
+	saved, err := ioutil.ReadFile("out/16x16x8.lif")
+	if err != nil {
+		t.Fatalf("Expected the final world to be saved as a Life 1.06 pattern: %v", err)
+	}
+	if string(saved) != "#Life 1.06\n5 3\n6 4\n4 5\n5 5\n6 5\n" {
+		t.Errorf("Expected the saved pattern to be the moved glider, got %q", saved)
+	}
+}
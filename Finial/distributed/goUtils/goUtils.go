@@ -7,4 +7,6 @@ type Params struct {
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Rule        string
+	Topology    string
 }
@@ -2,9 +2,7 @@ package gol
 
 import (
 	"fmt"
-	"log"
 	"net/rpc"
-	"os"
 	"time"
 	"uk.ac.bris.cs/gameoflife/goUtils"
 	"uk.ac.bris.cs/gameoflife/stubs"
@@ -18,51 +16,128 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioLoaded   <-chan error
 	keyPresses <-chan rune
 }
 
 // distributor divides the work between workers and interacts with other goroutines.
 func distributor(p Params, c distributorChannels) {
-
-	//connect to the server
-	//client, err := rpc.Dial("tcp", "34.229.9.86:8030")
-	client, err := rpc.Dial("tcp", "127.0.0.1:8034")
+	// Reject a bad rule or topology before anything is sent to the broker.
+	_, err := util.ParseRule(p.Rule)
+	if err != nil {
+		stopGame(c, 0, err)
+		return
+	}
+	_, err = util.ParseTopology(p.Topology)
 	if err != nil {
-		log.Fatal(err)
+		stopGame(c, 0, err)
+		return
 	}
 
 	// TODO: Create a 2D slice to store the world.
 	world := createNewWorld(p.ImageHeight, p.ImageWidth)
-	world = loadWorld(p, c)
 	turn := 0
-	request := stubs.Request{World: world, Params: convertParams(p)}
-	response := new(stubs.Response)
-	err = client.Call(stubs.LoadWorldToBroker, request, response)
+	if !p.Attach {
+		// Load the world first, so a file that can't be read never reaches the broker.
+		if p.Resume != "" {
+			world, turn, err = resumeWorld(p, c)
+		} else {
+			world, err = loadWorld(p, c)
+		}
+		if err != nil {
+			stopGame(c, 0, fmt.Errorf("loading the world: %v", err))
+			return
+		}
+	}
+
+	//connect to the server
+	//client, err := rpc.Dial("tcp", "34.229.9.86:8030")
+	client, err := dialBroker(p)
+	if err != nil {
+		stopGame(c, turn, fmt.Errorf("connecting to the broker: %v", err))
+		return
+	}
+	defer client.Close()
+
+	session := 0
+	if p.Attach {
+		world, turn, session, err = attachWorld(p, c, client)
+	} else {
+		request := stubs.Request{World: world, Params: convertParams(p), Turn: turn}
+		response := new(stubs.Response)
+		err = client.Call(stubs.LoadWorldToBroker, request, response)
+		session = response.Session
+	}
+	if err != nil {
+		stopGame(c, turn, err)
+		return
+	}
 
 	ticker := time.NewTicker(time.Second * 2) //Event should be sent every 2s.
 
 	defer ticker.Stop()
+	// The next checkpoint is timed from the end of the last, so slow saves never hold up the game.
+	var checkpoint <-chan time.Time
+	if p.Checkpoint != "" && p.CheckpointEvery > 0 {
+		checkpoint = time.After(p.CheckpointEvery)
+	}
+	// The ticker and keypresses are handled until the final turn is back, and
+	// stopped before finalizeGame closes the events channel. Quitting with q or k
+	// hangs up on the broker, so the call for the final turn comes back early.
+	done := make(chan bool)
+	stopped := make(chan bool)
+	quitting := make(chan int, 1)
+	// The goroutine keeps the first error from the broker for when the game is over.
+	failures := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case failures <- err:
+		default:
+		}
+	}
 	go func() {
+		delay := time.Duration(0)
 		for {
 			select {
+			case <-done:
+				close(stopped)
+				return
 			case <-ticker.C:
-				req := stubs.Request{}
+				req := stubs.Request{Session: session}
 				res := new(stubs.Response)
-				err = client.Call(stubs.AggregateCellNumbers, req, res)
+				err := client.Call(stubs.AggregateCellNumbers, req, res)
+				if err != nil {
+					report(err)
+					break
+				}
 				c.events <- AliveCellsCount{res.Turn, res.Cellnum}
+				sendStateChanges(p, c, client, session)
 
-				err = client.Call(stubs.AggregateCellFlip, req, res)
-				for _, change := range res.StateChanges {
-					c.events <- CellFlipped{change.Turn, change.Cell}
+			case <-checkpoint:
+				// The broker's world and turn always belong together, so any turn can be saved.
+				res := new(stubs.Response)
+				if err := client.Call(stubs.AggregateCurrentState, stubs.Request{Session: session}, res); err != nil {
+					fmt.Println("Error saving checkpoint:", err)
+				} else {
+					saveCheckpoint(p, res.World, res.Turn)
 				}
-
-				c.events <- TurnComplete{res.Turn}
+				checkpoint = time.After(p.CheckpointEvery)
 
 			case key := <-c.keyPresses:
-				req := stubs.Request{}
+				req := stubs.Request{Session: session}
 				res := new(stubs.Response)
-				err = client.Call(stubs.AggregateCurrentState, req, res)
-				handleKeyPress(p, key, c, res.World, res.Turn, client, req, res)
+				err := client.Call(stubs.AggregateCurrentState, req, res)
+				if err != nil {
+					report(err)
+					break
+				}
+				if handleKeyPress(p, key, c, res.World, res.Turn, client, req, res, &delay) {
+					quitting <- res.Turn
+					client.Close()
+					<-done
+					close(stopped)
+					return
+				}
 
 			}
 
@@ -71,9 +146,37 @@ func distributor(p Params, c distributorChannels) {
 	}()
 
 	//excute turns
-	req := stubs.Request{World: world, Params: convertParams(p)}
+	req := stubs.Request{Session: session}
 	res := new(stubs.Response)
-	err = client.Call(stubs.CallServerProcessWorld, req, res)
+	var processErr error
+	if p.Attach {
+		processErr = client.Call(stubs.WaitForSession, req, res)
+	} else {
+		processErr = client.Call(stubs.CallServerProcessWorld, req, res)
+	}
+
+	close(done)
+	<-stopped
+	select {
+	case err := <-failures:
+		fmt.Println("Error from the broker while running:", err)
+	default:
+	}
+	select {
+	case turn := <-quitting:
+		c.ioCommand <- ioCheckIdle
+		<-c.ioIdle
+		c.events <- StateChange{turn, Quitting}
+		close(c.events)
+		return
+	default:
+	}
+	if processErr != nil {
+		stopGame(c, turn, fmt.Errorf("running the game: %v", processErr))
+		return
+	}
+	// The broker has forgotten the session, and sent the changes made since the ticker last asked for them.
+	sendCellChanges(p, c, res)
 
 	turn = res.Turn
 	world = res.World
@@ -82,57 +185,138 @@ func distributor(p Params, c distributorChannels) {
 
 }
 
-func handleKeyPress(p Params, key rune, c distributorChannels, world [][]uint8, turn int, client *rpc.Client, req stubs.Request, res *stubs.Response) {
+// handleKeyPress handles a key and reports whether the controller should quit.
+func handleKeyPress(p Params, key rune, c distributorChannels, world [][]uint8, turn int, client *rpc.Client, req stubs.Request, res *stubs.Response, delay *time.Duration) bool {
 	switch key {
 	case 's':
 		//handle save command
-		c.ioCommand <- ioOutput
 		fileName := fmt.Sprintf("output_%d", turn)
-		c.ioFilename <- fileName
-		for y := 0; y < p.ImageHeight; y++ {
-			for x := 0; x < p.ImageWidth; x++ {
-				c.ioOutput <- world[y][x]
-			}
-		}
+		writeWorld(p, c, fileName, world)
 		c.events <- ImageOutputComplete{turn, fileName}
 		fmt.Println("Saved current state to PGM image.")
 	case 'q':
-		client.Call(stubs.DisconnectAllServers, req, res)
-		c.ioCommand <- ioCheckIdle
-		<-c.ioIdle
-		c.events <- StateChange{res.Turn, Quitting}
-		close(c.events)
-		os.Exit(0)
+		// The broker carries on without us; run with Params.Attach to come back.
+		client.Call(stubs.DetachClient, req, res)
+		return true
 	case 'p':
+		// The broker answers once every server has stopped, with the turn they stopped after.
+		err := client.Call(stubs.PauseAllServers, req, res)
+		if err != nil {
+			fmt.Println("Error pausing:", err)
+			return false
+		}
 		c.events <- StateChange{res.Turn, Paused}
-		client.Call(stubs.PauseAllServers, req, res)
+		// next is a key that ended typing a turn after f, to be handled next.
+		next := rune(0)
 		for {
-			tem := <-c.keyPresses
+			tem := next
+			if tem == 0 {
+				tem = <-c.keyPresses
+			}
+			next = 0
 			if tem == 'p' {
 				client.Call(stubs.UnPauseAllServers, req, res)
 				c.events <- StateChange{res.Turn, Executing}
 				break
 			}
-		}
-
-	case 'k':
-		fileName := fmt.Sprintf("%dx%dx%d", p.ImageHeight, p.ImageWidth, res.Turn)
-		c.ioCommand <- ioOutput
-		c.ioFilename <- fileName
-		for y := 0; y < p.ImageHeight; y++ {
-			for x := 0; x < p.ImageWidth; x++ {
-				c.ioOutput <- world[y][x]
+			// n and f run the paused session on, then send the cells that changed.
+			advance := stubs.Request{Session: req.Session}
+			switch tem {
+			case 'n':
+				advance.Turn = res.Turn + 1
+			case 'f':
+				advance.Turn, next = readTurn(c.keyPresses)
+			case '+', '-':
+				setTurnDelay(client, req, throttle(*delay, tem), delay)
+			}
+			if advance.Turn > res.Turn {
+				err := client.Call(stubs.AdvanceSession, advance, res)
+				if err != nil {
+					fmt.Println("Error advancing:", err)
+				}
+				sendStateChanges(p, c, client, req.Session)
+			}
+			// The broker ends the session once the final turn is reached, paused or not.
+			if res.Turn == p.Turns {
+				client.Call(stubs.UnPauseAllServers, req, res)
+				c.events <- StateChange{res.Turn, Executing}
+				break
 			}
 		}
 
+	case 'k':
+		fileName := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, res.Turn)
+		writeWorld(p, c, fileName, world)
 		c.events <- ImageOutputComplete{res.Turn, fileName}
 		client.Call(stubs.ShutDownAllServers, req, res)
-		c.ioCommand <- ioCheckIdle
-		<-c.ioIdle
-		c.events <- StateChange{res.Turn, Quitting}
-		close(c.events)
-		os.Exit(0)
+		return true
+
+	case '+', '-':
+		setTurnDelay(client, req, throttle(*delay, key), delay)
+	}
+	return false
+}
+
+// setTurnDelay asks the broker to wait newDelay after each turn and keeps it in delay.
+func setTurnDelay(client *rpc.Client, req stubs.Request, newDelay time.Duration, delay *time.Duration) {
+	req.Delay = newDelay
+	err := client.Call(stubs.SetTurnDelay, req, new(stubs.Response))
+	if err != nil {
+		fmt.Println("Error setting the turn rate:", err)
+		return
+	}
+	*delay = newDelay
+}
+
+// sendCellChange sends CellFlipped when a cell becomes alive or stops being alive,
+// and CellStateChanged when it enters or leaves a decay state.
+func sendCellChange(c distributorChannels, turn int, cell util.Cell, oldState, newState uint8) {
+	if oldState == 255 || newState == 255 {
+		c.events <- CellFlipped{turn, cell}
+	}
+	if util.IsDecaying(oldState) || util.IsDecaying(newState) {
+		c.events <- CellStateChanged{turn, cell, newState}
+	}
+}
+
+// cellEvents collects the cell changes of one turn so that its flips are sent
+// as a single CellsFlipped. With Params.SingleCellEvents every change is sent
+// straight away instead.
+type cellEvents struct {
+	c       distributorChannels
+	turn    int
+	single  bool
+	flipped []util.Cell
+	decayed []CellStateChanged
+}
+
+func newCellEvents(p Params, c distributorChannels, turn int) *cellEvents {
+	return &cellEvents{c: c, turn: turn, single: p.SingleCellEvents}
+}
+
+// change records a cell changing from oldState to newState.
+func (e *cellEvents) change(cell util.Cell, oldState, newState uint8) {
+	if e.single {
+		sendCellChange(e.c, e.turn, cell, oldState, newState)
+		return
+	}
+	if oldState == 255 || newState == 255 {
+		e.flipped = append(e.flipped, cell)
+	}
+	if util.IsDecaying(oldState) || util.IsDecaying(newState) {
+		e.decayed = append(e.decayed, CellStateChanged{e.turn, cell, newState})
+	}
+}
+
+// send sends the collected flips and then the decay changes, which have to follow the flips of the same cells.
+func (e *cellEvents) send() {
+	if len(e.flipped) > 0 {
+		e.c.events <- CellsFlipped{e.turn, e.flipped}
+	}
+	for _, event := range e.decayed {
+		e.c.events <- event
 	}
+	e.flipped, e.decayed = nil, nil
 }
 
 func calculateAliveCells(world [][]uint8) []util.Cell {
@@ -150,14 +334,8 @@ func calculateAliveCells(world [][]uint8) []util.Cell {
 func finalizeGame(p Params, c distributorChannels, world [][]uint8, turn int) {
 	// TODO: Report the final state using FinalTurnCompleteEvent.
 	//output
-	fileName := fmt.Sprintf("%dx%dx%d", p.ImageHeight, p.ImageWidth, turn)
-	c.ioCommand <- ioOutput
-	c.ioFilename <- fileName
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			c.ioOutput <- world[y][x]
-		}
-	}
+	fileName := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
+	writeWorld(p, c, fileName, world)
 
 	c.events <- ImageOutputComplete{turn, fileName}
 
@@ -173,26 +351,194 @@ func finalizeGame(p Params, c distributorChannels, world [][]uint8, turn int) {
 
 }
 
+// writeWorld saves the world as <fileName>.pgm in the output directory. A game started from a
+// pattern is saved as a pattern in the same format too.
+func writeWorld(p Params, c distributorChannels, fileName string, world [][]uint8) {
+	commands := []ioCommand{ioOutput}
+	if p.Pattern != "" {
+		commands = append(commands, patternOutputs[p.PatternFormat])
+	}
+	for _, command := range commands {
+		c.ioCommand <- command
+		c.ioFilename <- fileName
+		for y := 0; y < p.ImageHeight; y++ {
+			for x := 0; x < p.ImageWidth; x++ {
+				c.ioOutput <- world[y][x]
+			}
+		}
+	}
+}
+
 // load world data
-func loadWorld(p Params, c distributorChannels) [][]uint8 {
-	c.ioCommand <- ioInput
+func loadWorld(p Params, c distributorChannels) ([][]uint8, error) {
 	res := createNewWorld(p.ImageHeight, p.ImageWidth)
-	filename := fmt.Sprintf("%dx%d", p.ImageHeight, p.ImageWidth)
-	c.ioFilename <- filename
+	if p.Pattern != "" {
+		c.ioCommand <- patternInputs[p.PatternFormat]
+		c.ioFilename <- p.Pattern
+	} else {
+		c.ioCommand <- ioInput
+		c.ioFilename <- inputPath(p)
+	}
+	err := <-c.ioLoaded
+	if err != nil {
+		return nil, err
+	}
+	events := newCellEvents(p, c, 0)
 	for y := 0; y < p.ImageHeight; y++ {
 		for x := 0; x < p.ImageWidth; x++ {
 			input := <-c.ioInput
 			if input != 0 {
 				res[y][x] = input
-				c.events <- CellFlipped{0, util.Cell{X: y, Y: x}}
+				events.change(util.Cell{X: x, Y: y}, 0, input)
 			}
 		}
 	}
-	return res
+	events.send()
+	return res, nil
+}
+
+// sendStateChanges sends the cells that changed since it was last called, then
+// TurnComplete for the broker's turn.
+func sendStateChanges(p Params, c distributorChannels, client *rpc.Client, session int) {
+	res := new(stubs.Response)
+	err := client.Call(stubs.AggregateCellFlip, stubs.Request{Session: session}, res)
+	if err != nil {
+		return
+	}
+	sendCellChanges(p, c, res)
+}
+
+// sendCellChanges sends the cells that changed in res, then TurnComplete for its turn.
+func sendCellChanges(p Params, c distributorChannels, res *stubs.Response) {
+	// The changes come in turn order, so each turn's flips make one batch.
+	var events *cellEvents
+	for _, change := range res.StateChanges {
+		if events == nil || events.turn != change.Turn {
+			if events != nil {
+				events.send()
+			}
+			events = newCellEvents(p, c, change.Turn)
+		}
+		events.change(change.Cell, change.Previous, change.Value)
+	}
+	if events != nil {
+		events.send()
+	}
+	c.events <- TurnComplete{res.Turn}
+}
+
+// dialBroker connects to the broker at p.BrokerAddr.
+func dialBroker(p Params) (*rpc.Client, error) {
+	brokerAddr := p.BrokerAddr
+	if brokerAddr == "" {
+		brokerAddr = "127.0.0.1:8034"
+	}
+	return rpc.Dial("tcp", brokerAddr)
+}
+
+// SessionParams returns p with the size, turns, rule and topology of session
+// p.Session on the broker at p.BrokerAddr, or of its newest session if that is 0,
+// so that a controller can attach to it. The session is set too.
+func SessionParams(p Params) (Params, error) {
+	client, err := dialBroker(p)
+	if err != nil {
+		return p, err
+	}
+	defer client.Close()
+	res := new(stubs.Response)
+	err = client.Call(stubs.GetSession, stubs.Request{Session: p.Session}, res)
+	if err != nil {
+		return p, err
+	}
+	p.Turns = res.Params.Turns
+	p.ImageWidth = res.Params.ImageWidth
+	p.ImageHeight = res.Params.ImageHeight
+	p.Rule = res.Params.Rule
+	p.Topology = res.Params.Topology
+	p.Session = res.Session
+	return p, nil
+}
+
+// attachWorld takes over session p.Session on the broker and returns its world,
+// turn and ID. The game has to have the size of p.
+func attachWorld(p Params, c distributorChannels, client *rpc.Client) ([][]uint8, int, int, error) {
+	res := new(stubs.Response)
+	err := client.Call(stubs.AttachClient, stubs.Request{Session: p.Session}, res)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if res.Params.ImageWidth != p.ImageWidth || res.Params.ImageHeight != p.ImageHeight {
+		return nil, 0, 0, fmt.Errorf("the broker's game is %dx%d, not %dx%d", res.Params.ImageWidth, res.Params.ImageHeight, p.ImageWidth, p.ImageHeight)
+	}
+	events := newCellEvents(p, c, res.Turn)
+	for y, row := range res.World {
+		for x, value := range row {
+			if value != 0 {
+				events.change(util.Cell{X: x, Y: y}, 0, value)
+			}
+		}
+	}
+	events.send()
+	return res.World, res.Turn, res.Session, nil
+}
+
+// stopGame ends a game that can't go on after turn because of err.
+func stopGame(c distributorChannels, turn int, err error) {
+	fmt.Println("Error:", err)
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+	c.events <- StateChange{turn, Quitting}
+	close(c.events)
+}
+
+// resumeWorld loads the world and turn saved in the checkpoint p.Resume, which
+// has to be from a game of the same size, rule and topology as p.
+func resumeWorld(p Params, c distributorChannels) ([][]uint8, int, error) {
+	cp, err := ReadCheckpoint(p.Resume)
+	if err != nil {
+		return nil, 0, err
+	}
+	rule, _ := util.ParseRule(p.Rule)
+	topology, _ := util.ParseTopology(p.Topology)
+	savedRule, _ := util.ParseRule(cp.Params.Rule)
+	savedTopology, _ := util.ParseTopology(cp.Params.Topology)
+	if cp.Params.ImageWidth != p.ImageWidth || cp.Params.ImageHeight != p.ImageHeight || savedRule.String() != rule.String() || savedTopology != topology {
+		return nil, 0, fmt.Errorf("checkpoint %s is from a %dx%d %s game on a %s, not %dx%d %s on a %s", p.Resume,
+			cp.Params.ImageWidth, cp.Params.ImageHeight, savedRule, savedTopology, p.ImageWidth, p.ImageHeight, rule, topology)
+	}
+	if cp.Turn > p.Turns {
+		return nil, 0, fmt.Errorf("checkpoint %s is already past turn %d", p.Resume, p.Turns)
+	}
+	events := newCellEvents(p, c, cp.Turn)
+	for y, row := range cp.World {
+		for x, value := range row {
+			if value != 0 {
+				events.change(util.Cell{X: x, Y: y}, 0, value)
+			}
+		}
+	}
+	events.send()
+	return cp.World, cp.Turn, nil
+}
+
+// saveCheckpoint saves the world after turn to p.Checkpoint. A checkpoint that
+// cannot be saved does not stop the game.
+func saveCheckpoint(p Params, world [][]uint8, turn int) {
+	err := WriteCheckpoint(p.Checkpoint, Checkpoint{Params: p, Turn: turn, World: world})
+	if err != nil {
+		fmt.Println("Error saving checkpoint:", err)
+	}
 }
 
 func convertParams(p Params) goUtils.Params {
-	return goUtils.Params{p.Turns, p.Threads, p.ImageWidth, p.ImageHeight}
+	return goUtils.Params{
+		Turns:       p.Turns,
+		Threads:     p.Threads,
+		ImageWidth:  p.ImageWidth,
+		ImageHeight: p.ImageHeight,
+		Rule:        p.Rule,
+		Topology:    p.Topology,
+	}
 }
 
 // create a new world
@@ -52,9 +52,26 @@ type CellFlipped struct { // implements Event
 	Cell           util.Cell
 }
 
+// CellsFlipped is an Event notifying the GUI about a change of state of many cells at once.
+// By default changed cells are sent in batches as CellsFlipped instead of one
+// CellFlipped per cell; Params.SingleCellEvents sends CellFlipped instead.
+type CellsFlipped struct { // implements Event
+	CompletedTurns int
+	Cells          []util.Cell
+}
+
+// CellStateChanged is an Event notifying the GUI that a cell entered or left one of
+// the decay states of a Generations rule. Value is the new grey level of the cell.
+// When an alive cell starts decaying, CellFlipped or CellsFlipped is sent before this Event.
+type CellStateChanged struct { // implements Event
+	CompletedTurns int
+	Cell           util.Cell
+	Value          uint8
+}
+
 // TurnComplete is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
-// All CellFlipped events must be sent *before* TurnComplete.
+// All CellFlipped and CellsFlipped events must be sent *before* TurnComplete.
 type TurnComplete struct { // implements Event
 	CompletedTurns int
 }
@@ -114,6 +131,22 @@ func (event CellFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CellsFlipped) String() string {
+	return fmt.Sprintf("")
+}
+
+func (event CellsFlipped) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event CellStateChanged) String() string {
+	return fmt.Sprintf("")
+}
+
+func (event CellStateChanged) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return fmt.Sprintf("")
 }
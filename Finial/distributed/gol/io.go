@@ -2,10 +2,9 @@ package gol
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -16,12 +15,14 @@ type ioChannels struct {
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	loaded   chan<- error // nil before the cells of an image or pattern are sent, or why it can't be loaded
 }
 
 // ioState is the internal ioState of the io goroutine.
 type ioState struct {
 	params   Params
 	channels ioChannels
+	format   PatternFormat // format of the last pattern read
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -32,20 +33,63 @@ type ioCommand uint8
 //		ioOutput 	= 0
 //		ioInput 	= 1
 //		ioCheckIdle = 2
+//		ioInputPattern = 3
+//		...
+//
+// The pattern commands read and write pattern files in one format each.
+// ioInputPattern picks the format from the file's header and ioOutputPattern
+// writes in the format the pattern was read in.
 const (
 	ioOutput ioCommand = iota
 	ioInput
 	ioCheckIdle
+	ioInputPattern
+	ioInputRLE
+	ioInputCells
+	ioInputLife105
+	ioInputLife106
+	ioOutputPattern
+	ioOutputRLE
+	ioOutputCells
+	ioOutputLife105
+	ioOutputLife106
 )
 
+// patternOutputs gives the command that writes a pattern in each format.
+var patternOutputs = map[PatternFormat]ioCommand{
+	FormatAuto:    ioOutputPattern,
+	FormatRLE:     ioOutputRLE,
+	FormatCells:   ioOutputCells,
+	FormatLife105: ioOutputLife105,
+	FormatLife106: ioOutputLife106,
+}
+
+// patternInputs gives the command that reads a pattern in each format.
+var patternInputs = map[PatternFormat]ioCommand{
+	FormatAuto:    ioInputPattern,
+	FormatRLE:     ioInputRLE,
+	FormatCells:   ioInputCells,
+	FormatLife105: ioInputLife105,
+	FormatLife106: ioInputLife106,
+}
+
+// outputPath returns the path of an output file in Params.OutputDir, or in out
+// if it is empty, and makes the directory if it is not there.
+func (io *ioState) outputPath(name string) string {
+	dir := io.params.OutputDir
+	if dir == "" {
+		dir = "out"
+	}
+	_ = os.MkdirAll(dir, os.ModePerm)
+	return filepath.Join(dir, name)
+}
+
 // writePgmImage receives an array of bytes and writes it to a pgm file.
 func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
-
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
 
-	file, ioError := os.Create("out/" + filename + ".pgm")
+	file, ioError := os.Create(io.outputPath(filename + ".pgm"))
 	util.Check(ioError)
 	defer file.Close()
 
@@ -86,45 +130,131 @@ func (io *ioState) writePgmImage() {
 	fmt.Println("File", filename, "output done!")
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
-
+// writePattern receives an array of bytes and writes it to a pattern file in the
+// given format, or in the format the pattern was read in for FormatAuto.
+func (io *ioState) writePattern(format PatternFormat) {
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
 
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
+	if format == FormatAuto {
+		format = io.format
+	}
+
+	world := make([][]byte, io.params.ImageHeight)
+	for y := range world {
+		world[y] = make([]byte, io.params.ImageWidth)
+		for x := range world[y] {
+			world[y][x] = <-io.channels.output
+		}
+	}
+
+	file, ioError := os.Create(io.outputPath(filename + "." + format.Extension()))
 	util.Check(ioError)
+	defer file.Close()
+	pattern := Pattern{Name: filename, Rule: io.params.Rule, Cells: world}
+	ioError = WritePattern(file, pattern, format)
+	if ioError != nil {
+		// The image has been saved already, so the game carries on.
+		fmt.Println("File", filename, "not saved as", format, "pattern:", ioError)
+		return
+	}
+	ioError = file.Sync()
+	util.Check(ioError)
+
+	fmt.Println("File", filename, "output done!")
+}
 
-	fields := strings.Fields(string(data))
+// readPattern opens a pattern file in the given format, or in the format picked
+// from its header for FormatAuto, and sends the board with the pattern placed on it.
+// If the pattern can't be loaded the error is sent instead.
+func (io *ioState) readPattern(format PatternFormat) {
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+	// Request the path of the pattern from the distributor.
+	filename := <-io.channels.filename
+
+	world, format, ioError := io.loadPattern(filename, format)
+	io.channels.loaded <- ioError
+	if ioError != nil {
+		return
 	}
+	io.format = format
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+	for _, row := range world {
+		for _, b := range row {
+			io.channels.input <- b
+		}
 	}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	fmt.Println("File", filename, "input done!")
+}
+
+// loadPattern reads a pattern file and places the pattern on the board.
+func (io *ioState) loadPattern(filename string, format PatternFormat) ([][]uint8, PatternFormat, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, format, err
 	}
+	defer file.Close()
+	pattern, format, err := ReadPattern(file, format)
+	if err != nil {
+		return nil, format, fmt.Errorf("%s: %v", filename, err)
+	}
+	world, err := placePattern(pattern, io.params.ImageWidth, io.params.ImageHeight, io.params.PatternAt)
+	return world, format, err
+}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+// inputPath returns the path of the image to load: Params.InputPath, or the
+// image of the board's size in images if it is empty.
+func inputPath(p Params) string {
+	if p.InputPath != "" {
+		return p.InputPath
 	}
+	return fmt.Sprintf("images/%dx%d.pgm", p.ImageWidth, p.ImageHeight)
+}
 
-	image := []byte(fields[4])
+// readPgmImage opens a netpbm image and sends its cells as an array of bytes.
+// If the image can't be loaded the error is sent instead.
+func (io *ioState) readPgmImage() {
 
-	for _, b := range image {
-		io.channels.input <- b
+	// Request the path of the image from the distributor.
+	filename := <-io.channels.filename
+
+	image, ioError := io.loadImage(filename)
+	io.channels.loaded <- ioError
+	if ioError != nil {
+		return
+	}
+
+	for _, row := range image {
+		for _, b := range row {
+			io.channels.input <- b
+		}
 	}
 
 	fmt.Println("File", filename, "input done!")
 }
 
+// loadImage reads a netpbm image, which has to be the size of the board.
+func (io *ioState) loadImage(filename string) ([][]uint8, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rule, err := util.ParseRule(io.params.Rule)
+	if err != nil {
+		return nil, err
+	}
+	image, err := ReadImage(file, rule)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(image) != io.params.ImageHeight || len(image[0]) != io.params.ImageWidth {
+		return nil, fmt.Errorf("%s is %dx%d, not %dx%d", filename, len(image[0]), len(image), io.params.ImageWidth, io.params.ImageHeight)
+	}
+	return image, nil
+}
+
 // startIo should be the entrypoint of the io goroutine.
 func startIo(p Params, c ioChannels) {
 	io := ioState{
@@ -139,6 +269,26 @@ func startIo(p Params, c ioChannels) {
 			switch command {
 			case ioInput:
 				io.readPgmImage()
+			case ioInputPattern:
+				io.readPattern(FormatAuto)
+			case ioInputRLE:
+				io.readPattern(FormatRLE)
+			case ioInputCells:
+				io.readPattern(FormatCells)
+			case ioInputLife105:
+				io.readPattern(FormatLife105)
+			case ioInputLife106:
+				io.readPattern(FormatLife106)
+			case ioOutputPattern:
+				io.writePattern(FormatAuto)
+			case ioOutputRLE:
+				io.writePattern(FormatRLE)
+			case ioOutputCells:
+				io.writePattern(FormatCells)
+			case ioOutputLife105:
+				io.writePattern(FormatLife105)
+			case ioOutputLife106:
+				io.writePattern(FormatLife106)
 			case ioOutput:
 				io.writePgmImage()
 			case ioCheckIdle:
@@ -0,0 +1,221 @@
+package gol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// maxImageSide is the widest and tallest image ReadImage loads.
+const maxImageSide = 1 << 16
+
+// netpbmHeader is the start of a netpbm file: the magic number P1 (ASCII bitmap),
+// P2 (ASCII greymap), P4 (binary bitmap) or P5 (binary greymap), the size and,
+// for greymaps, the grey level of white.
+type netpbmHeader struct {
+	magic         string
+	width, height int
+	maxVal        int
+}
+
+// ImageSize returns the width and height given in the header of a netpbm image.
+func ImageSize(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	header, err := readNetpbmHeader(bufio.NewReader(file))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return header.width, header.height, nil
+}
+
+// ReadImage reads a P1, P2, P4 or P5 netpbm image, with # comments anywhere in
+// the header, and returns its rows of cells. In bitmaps a 1 (black) pixel is
+// alive. In greymaps a pixel brighter than half of white is alive, except that
+// with a Generations rule the grey levels of its decay states in 8-bit images
+// are kept, so saved worlds load back as they were.
+func ReadImage(r io.Reader, rule util.Rule) ([][]uint8, error) {
+	br := bufio.NewReader(r)
+	header, err := readNetpbmHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	states := map[int]bool{}
+	if header.maxVal == 255 && rule.States > 2 {
+		for k := 0; k < rule.States-1; k++ {
+			states[int(rule.StateValue(k))] = true
+		}
+	}
+	cell := func(grey int) uint8 {
+		if states[grey] {
+			return uint8(grey)
+		}
+		if grey*2 > header.maxVal {
+			return 255
+		}
+		return 0
+	}
+
+	cells := make([][]uint8, 0, header.height)
+	var raster []byte
+	switch header.magic {
+	case "P4":
+		raster = make([]byte, (header.width+7)/8)
+	case "P5":
+		raster = make([]byte, header.width)
+		if header.maxVal > 255 {
+			raster = make([]byte, header.width*2)
+		}
+	}
+	for y := 0; y < header.height; y++ {
+		row := make([]uint8, header.width)
+		if raster != nil {
+			_, err = io.ReadFull(br, raster)
+		}
+		for x := 0; x < header.width && err == nil; x++ {
+			switch header.magic {
+			case "P1":
+				row[x], err = readBit(br)
+			case "P2":
+				var grey int
+				grey, err = readNumber(br)
+				if err == nil && grey > header.maxVal {
+					return nil, fmt.Errorf("grey level %d is above the maximum %d", grey, header.maxVal)
+				}
+				row[x] = cell(grey)
+			case "P4":
+				if raster[x/8]&(0x80>>uint(x%8)) != 0 {
+					row[x] = 255
+				}
+			case "P5":
+				grey := int(raster[x])
+				if header.maxVal > 255 {
+					grey = int(raster[2*x])<<8 | int(raster[2*x+1])
+				}
+				if grey > header.maxVal {
+					return nil, fmt.Errorf("grey level %d is above the maximum %d", grey, header.maxVal)
+				}
+				row[x] = cell(grey)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("image ends after %d of %d rows", y, header.height)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, row)
+	}
+	return cells, nil
+}
+
+// readNetpbmHeader reads the header of a netpbm image. For binary images it
+// also reads the single whitespace byte before the pixels.
+func readNetpbmHeader(r *bufio.Reader) (netpbmHeader, error) {
+	var header netpbmHeader
+	magic := make([]byte, 2)
+	_, err := io.ReadFull(r, magic)
+	header.magic = string(magic)
+	if err != nil || (header.magic != "P1" && header.magic != "P2" && header.magic != "P4" && header.magic != "P5") {
+		return header, errors.New("not a P1, P2, P4 or P5 netpbm image")
+	}
+	header.width, err = readNumber(r)
+	if err == nil {
+		header.height, err = readNumber(r)
+	}
+	header.maxVal = 1
+	if err == nil && (header.magic == "P2" || header.magic == "P5") {
+		header.maxVal, err = readNumber(r)
+	}
+	if err != nil {
+		return header, fmt.Errorf("invalid image header: %v", err)
+	}
+	if header.width < 1 || header.height < 1 || header.width > maxImageSide || header.height > maxImageSide {
+		return header, fmt.Errorf("invalid image size %dx%d", header.width, header.height)
+	}
+	if header.maxVal < 1 || header.maxVal > 65535 {
+		return header, fmt.Errorf("invalid maximum grey level %d", header.maxVal)
+	}
+	if header.magic == "P4" || header.magic == "P5" {
+		b, err := r.ReadByte()
+		if err != nil || !isSpace(b) {
+			return header, errors.New("invalid image header: no whitespace before the pixels")
+		}
+	}
+	return header, nil
+}
+
+// readNumber reads a decimal number, skipping whitespace and # comments before it.
+func readNumber(r *bufio.Reader) (int, error) {
+	err := skipSpace(r)
+	if err != nil {
+		return 0, err
+	}
+	n, digits := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF && digits > 0 {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b < '0' || b > '9' {
+			r.UnreadByte()
+			if digits == 0 {
+				return 0, fmt.Errorf("expected a number, got %q", b)
+			}
+			return n, nil
+		}
+		n = n*10 + int(b-'0')
+		digits++
+		if n > 1<<24 {
+			return 0, errors.New("number too large")
+		}
+	}
+}
+
+// readBit reads a pixel of a P1 image, which need not be separated by whitespace.
+func readBit(r *bufio.Reader) (uint8, error) {
+	err := skipSpace(r)
+	if err != nil {
+		return 0, err
+	}
+	b, _ := r.ReadByte()
+	switch b {
+	case '0':
+		return 0, nil
+	case '1':
+		return 255, nil
+	}
+	return 0, fmt.Errorf("expected 0 or 1, got %q", b)
+}
+
+// skipSpace skips whitespace and # comments, which run to the end of the line.
+func skipSpace(r *bufio.Reader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == '#':
+			_, err = r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+		case !isSpace(b):
+			return r.UnreadByte()
+		}
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
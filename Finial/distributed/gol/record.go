@@ -0,0 +1,177 @@
+package gol
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// RecordOptions picks the turns a Recorder keeps and how they are drawn.
+type RecordOptions struct {
+	Every int           // turns between frames; 0 means every turn
+	Scale int           // pixels per cell; 0 means 1
+	Delay time.Duration // time each frame is shown, in steps of 10ms
+	From  int           // first turn recorded
+	To    int           // last turn recorded; 0 means the end of the game
+}
+
+// Recorder rebuilds the board from the events of a game, like the SDL window
+// does, and writes it as a frame of an animated GIF every Every turns between
+// From and To. The distributed controller does not see every turn, so frames
+// are then taken at the first turn seen that is at least Every turns on.
+// Frames are written as they are taken, so a long game doesn't fill the memory.
+type Recorder struct {
+	options       RecordOptions
+	width, height int
+	board         []uint8 // the cells row by row
+	turn          int     // the turn the board is at
+	next          int     // the next turn to take a frame at
+	framed        bool    // whether the board at turn has been looked at for a frame
+	palette       color.Palette
+	w             io.Writer
+	frames        int
+	err           error // the first error writing to w
+}
+
+// NewRecorder returns a recorder for a width x height board that starts dead,
+// which writes the GIF to w.
+func NewRecorder(w io.Writer, width, height int, options RecordOptions) *Recorder {
+	if options.Every < 1 {
+		options.Every = 1
+	}
+	if options.Scale < 1 {
+		options.Scale = 1
+	}
+	// Grey level v is colour v, so the decay states of Generations rules show as they do in the window.
+	palette := make(color.Palette, 256)
+	for v := range palette {
+		palette[v] = color.Gray{Y: uint8(v)}
+	}
+	return &Recorder{
+		options: options,
+		width:   width,
+		height:  height,
+		board:   make([]uint8, width*height),
+		next:    options.From,
+		palette: palette,
+		w:       w,
+	}
+}
+
+// Record adds every event to the recorder and passes it on to the returned
+// channel, which is closed once events is.
+func (r *Recorder) Record(events <-chan Event) <-chan Event {
+	recorded := make(chan Event, cap(events))
+	go func() {
+		for event := range events {
+			r.Add(event)
+			recorded <- event
+		}
+		close(recorded)
+	}()
+	return recorded
+}
+
+// Add updates the board with an event. The board is complete for a turn at its
+// TurnComplete, or when an event of a later turn arrives, as there is no
+// TurnComplete for the world that was loaded.
+func (r *Recorder) Add(event Event) {
+	if event.GetCompletedTurns() > r.turn {
+		r.frame()
+		r.turn = event.GetCompletedTurns()
+		r.framed = false
+	}
+	switch e := event.(type) {
+	case CellFlipped:
+		r.flip(e.Cell.X, e.Cell.Y)
+	case CellsFlipped:
+		for _, cell := range e.Cells {
+			r.flip(cell.X, cell.Y)
+		}
+	case CellStateChanged:
+		r.board[e.Cell.Y*r.width+e.Cell.X] = e.Value
+	case TurnComplete, FinalTurnComplete:
+		r.frame()
+	}
+}
+
+// Frames returns the number of frames recorded so far.
+func (r *Recorder) Frames() int {
+	return r.frames
+}
+
+// Close ends the GIF. It returns the first error writing a frame, if any.
+func (r *Recorder) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.frames == 0 {
+		return errors.New("no frames were recorded")
+	}
+	_, err := r.w.Write([]byte{0x3b})
+	return err
+}
+
+func (r *Recorder) flip(x, y int) {
+	if r.board[y*r.width+x] == 0 {
+		r.board[y*r.width+x] = 255
+	} else {
+		r.board[y*r.width+x] = 0
+	}
+}
+
+// frame takes a frame of the board if its turn is one to record.
+func (r *Recorder) frame() {
+	if r.framed {
+		return
+	}
+	r.framed = true
+	if r.turn < r.next || (r.options.To > 0 && r.turn > r.options.To) {
+		return
+	}
+	r.next = r.turn + r.options.Every
+
+	scale := r.options.Scale
+	img := image.NewPaletted(image.Rect(0, 0, r.width*scale, r.height*scale), r.palette)
+	for y := 0; y < r.height*scale; y++ {
+		for x := 0; x < r.width*scale; x++ {
+			img.Pix[y*img.Stride+x] = r.board[(y/scale)*r.width+x/scale]
+		}
+	}
+	r.write(img)
+}
+
+// write encodes a frame on its own and writes its blocks to the GIF. Every frame
+// has the same size and palette, so only the first frame's header is written,
+// followed by the block that makes the GIF loop. The trailer is left to Close.
+func (r *Recorder) write(img *image.Paletted) {
+	if r.err != nil {
+		return
+	}
+	var buffer bytes.Buffer
+	anim := gif.GIF{Image: []*image.Paletted{img}, Delay: []int{int(r.options.Delay / (10 * time.Millisecond))}}
+	r.err = gif.EncodeAll(&buffer, &anim)
+	if r.err != nil {
+		return
+	}
+	data := buffer.Bytes()
+	// The header is 13 bytes and then the global colour table, if there is one.
+	header := 13
+	if data[10]&0x80 != 0 {
+		header += 3 << (data[10]&7 + 1)
+	}
+	if r.frames == 0 {
+		loop := append([]byte{0x21, 0xff, 0x0b}, "NETSCAPE2.0"...)
+		loop = append(loop, 0x03, 0x01, 0x00, 0x00, 0x00)
+		_, r.err = r.w.Write(append(data[:header:header], loop...))
+		if r.err != nil {
+			return
+		}
+	}
+	_, r.err = r.w.Write(data[header : len(data)-1])
+	r.frames++
+}
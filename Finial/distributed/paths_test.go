@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPaths loads the 33x17 image from a file of another name without giving its size and runs it
+// on a broker on port 8160 with 2 servers. The size has to be read from the image and the final
+// world has to be saved to the given directory.
+func TestPaths(t *testing.T) {
+	brokerAddr, stop := startLocalCluster(8160, 2)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "gol-paths")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("images/33x17.pgm")
+	util.Check(err)
+	input := filepath.Join(dir, "board.pgm")
+	util.Check(ioutil.WriteFile(input, data, 0644))
+	output := filepath.Join(dir, "saved")
+
+	p := gol.Params{Turns: 100, BrokerAddr: brokerAddr, InputPath: input, OutputDir: output}
+	expected := readAliveCells("check/images/33x17x100.pgm", 33, 17)
+	assertEqualBoard(t, runToFinalTurn(p), expected, p)
+
+	saved := filepath.Join(output, "33x17x100.pgm")
+	if _, err := os.Stat(saved); err != nil {
+		t.Fatalf("Expected the final world to be saved as %s: %v", saved, err)
+	}
+	assertEqualBoard(t, readAliveCells(saved, 33, 17), expected, p)
+}
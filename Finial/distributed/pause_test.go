@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/goUtils"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPause pauses the 512x512 image a few times on a broker on port 8110 with 2 servers. Every
+// pause has to stop at one turn, and the world read while paused has to be the world of that turn.
+func TestPause(t *testing.T) {
+	brokerAddr, stop := startProcessCluster(t, 8110, 2)
+	defer stop()
+
+	client, err := rpc.Dial("tcp", brokerAddr)
+	util.Check(err)
+	defer client.Close()
+	req := stubs.Request{
+		World:  readCellValues("images/512x512.pgm", 512, 512),
+		Params: goUtils.Params{Turns: 10000, ImageWidth: 512, ImageHeight: 512},
+	}
+	loaded := new(stubs.Response)
+	util.Check(client.Call(stubs.LoadWorldToBroker, req, loaded))
+	session := stubs.Request{Session: loaded.Session}
+	call := client.Go(stubs.CallServerProcessWorld, session, new(stubs.Response), nil)
+	defer func() {
+		client.Call(stubs.KillSession, session, new(stubs.Response))
+		<-call.Done
+	}()
+
+	alive := readAliveCounts(512, 512)
+	lastTurn := 0
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		paused := new(stubs.Response)
+		util.Check(client.Call(stubs.PauseAllServers, session, paused))
+		if paused.Turn <= lastTurn {
+			t.Errorf("Expected to pause after turn %d, paused at turn %d", lastTurn, paused.Turn)
+		}
+		if paused.Cellnum != alive[paused.Turn] {
+			t.Errorf("Paused at turn %d with %d alive cells, should be %d", paused.Turn, paused.Cellnum, alive[paused.Turn])
+		}
+
+		// Nothing moves while paused.
+		time.Sleep(200 * time.Millisecond)
+		counted := new(stubs.Response)
+		util.Check(client.Call(stubs.AggregateCellNumbers, session, counted))
+		state := new(stubs.Response)
+		util.Check(client.Call(stubs.AggregateCurrentState, session, state))
+		if counted.Turn != paused.Turn || state.Turn != paused.Turn {
+			t.Errorf("Paused at turn %d, but the broker reports turns %d and %d", paused.Turn, counted.Turn, state.Turn)
+		}
+		worldAlive := 0
+		for _, row := range state.World {
+			for _, value := range row {
+				if value == 255 {
+					worldAlive++
+				}
+			}
+		}
+		if worldAlive != alive[paused.Turn] {
+			t.Errorf("The world of turn %d has %d alive cells, should be %d", paused.Turn, worldAlive, alive[paused.Turn])
+		}
+
+		resumed := new(stubs.Response)
+		util.Check(client.Call(stubs.UnPauseAllServers, session, resumed))
+		if resumed.Turn != paused.Turn {
+			t.Errorf("Paused at turn %d, but resumed at turn %d", paused.Turn, resumed.Turn)
+		}
+		lastTurn = paused.Turn
+	}
+}
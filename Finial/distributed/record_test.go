@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRecord records the 64x64 image on a broker on port 8170 with 2 servers to an animated GIF.
+// The controller does not see every turn, so only the first and last frames are known: the image
+// and the check image of turn 100.
+func TestRecord(t *testing.T) {
+	brokerAddr, stop := startLocalCluster(8170, 2)
+	defer stop()
+
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100, BrokerAddr: brokerAddr}
+	var buffer bytes.Buffer
+	recorder := gol.NewRecorder(&buffer, p.ImageWidth, p.ImageHeight, gol.RecordOptions{Every: 1, Scale: 2})
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for range recorder.Record(events) {
+	}
+
+	util.Check(recorder.Close())
+	anim, err := gif.DecodeAll(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) < 2 {
+		t.Fatalf("Expected at least 2 frames, got %d", len(anim.Image))
+	}
+	frames := map[int]string{0: "check/images/64x64x0.pgm", len(anim.Image) - 1: "check/images/64x64x100.pgm"}
+	for frame, path := range frames {
+		expected := readCellValues(path, p.ImageWidth, p.ImageHeight)
+		img := anim.Image[frame]
+	check:
+		for y := 0; y < p.ImageHeight*2; y++ {
+			for x := 0; x < p.ImageWidth*2; x++ {
+				grey, _, _, _ := img.At(x, y).RGBA()
+				if uint8(grey>>8) != expected[y/2][x/2] {
+					t.Errorf("Frame %d is not %s: pixel (%d, %d) is %d", frame, path, x, y, grey>>8)
+					break check
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+const gliderRLE = `#N Glider
+#C The smallest spaceship.
+x = 3, y = 3, rule = 23/3
+bob$2bo$
+3o!
+`
+
+// TestRLE reads a glider pattern and runs it on a broker on port 8140 with 2 servers. The final
+// world has to be the moved glider, both in the events and in the saved pattern.
+func TestRLE(t *testing.T) {
+	pattern, err := gol.ReadRLE(strings.NewReader(gliderRLE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := gol.PatternRule(pattern)
+	if pattern.Name != "Glider" || len(pattern.Comments) != 1 || rule != "B3/S23" {
+		t.Errorf("Expected the glider's name, comment and rule B3/S23, got %q, %q and %q (%v)", pattern.Name, pattern.Comments, rule, err)
+	}
+	expectedCells := [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+	if !reflect.DeepEqual(pattern.Cells, expectedCells) {
+		t.Errorf("Expected the glider's cells %v, got %v", expectedCells, pattern.Cells)
+	}
+
+	brokerAddr, stop := startLocalCluster(8140, 2)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "gol-rle")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "glider.rle")
+	util.Check(ioutil.WriteFile(path, []byte(gliderRLE), 0644))
+
+	// The glider moves one cell down and right every 4 turns.
+	p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 8, BrokerAddr: brokerAddr, Pattern: path, PatternAt: &util.Cell{X: 2, Y: 1}}
+	expected := []util.Cell{{X: 5, Y: 3}, {X: 6, Y: 4}, {X: 4, Y: 5}, {X: 5, Y: 5}, {X: 6, Y: 5}}
+	assertEqualBoard(t, runToFinalTurn(p), expected, p)
+
+	file, err := os.Open("out/16x16x8.rle")
+	if err != nil {
+		t.Fatalf("Expected the final world to be saved as a pattern: %v", err)
+	}
+	defer file.Close()
+	saved, err := gol.ReadRLE(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cells []util.Cell
+	for y, row := range saved.Cells {
+		for x, value := range row {
+			if value == 255 {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	assertEqualBoard(t, cells, expected, p)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRule tests the 64x64 image on 1 and 100 turns under Conway, HighLife and Seeds rules using 1, 4 and 8 worker threads.
+func TestRule(t *testing.T) {
+	rules := []string{"B3/S23", "b36/s23", "B2/S"}
+	for _, rule := range rules {
+		for _, turns := range []int{1, 100} {
+			p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: turns, Rule: rule}
+			golden := fmt.Sprintf("%vx%vx%v", p.ImageWidth, p.ImageHeight, turns)
+			if parsed, _ := util.ParseRule(rule); parsed.String() != util.DefaultRule {
+				golden += "-" + strings.Replace(parsed.String(), "/", "", -1)
+			}
+			expectedAlive := readAliveCells("check/images/"+golden+".pgm", p.ImageWidth, p.ImageHeight)
+			for _, threads := range []int{1, 4, 8} {
+				p.Threads = threads
+				testName := fmt.Sprintf("%s-%dx%dx%d-%d", strings.Replace(rule, "/", "", 1), p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+				t.Run(testName, func(t *testing.T) {
+					events := make(chan gol.Event)
+					go gol.Run(p, events, nil)
+					var cells []util.Cell
+					for event := range events {
+						switch e := event.(type) {
+						case gol.FinalTurnComplete:
+							cells = e.Alive
+						}
+					}
+					assertEqualBoard(t, cells, expectedAlive, p)
+				})
+			}
+		}
+	}
+}
+
+// TestParseRule checks that rulestrings are parsed and invalid ones are rejected.
+func TestParseRule(t *testing.T) {
+	valid := map[string]string{
+		"":             "B3/S23",
+		"B3/S23":       "B3/S23",
+		"S23/B3":       "B3/S23",
+		"B36/S23":      "B36/S23",
+		"B2/S":         "B2/S",
+		"B3678/S34678": "B3678/S34678",
+		"B2/S/C3":      "B2/S/C3",
+		"b2/s345/c4":   "B2/S345/C4",
+		"B3/S23/C2":    "B3/S23",
+	}
+	for given, expected := range valid {
+		rule, err := util.ParseRule(given)
+		if err != nil {
+			t.Errorf("ParseRule(%q) returned error %v", given, err)
+		} else if rule.String() != expected {
+			t.Errorf("ParseRule(%q) = %v, expected %v", given, rule, expected)
+		}
+	}
+	for _, given := range []string{"B3", "B9/S23", "X3/S23", "B3/B3", "B3/S23/X", "B3/S2a", "B2/S/C1", "B2/S/C257", "B2/S/3"} {
+		if _, err := util.ParseRule(given); err == nil {
+			t.Errorf("ParseRule(%q) should have failed", given)
+		}
+	}
+}
+
+// TestGenerations tests the 100x75 image on 1 and 100 turns under Brian's Brain and Star Wars using 1, 4 and 8 worker threads.
+// Both the alive cells and the grey levels of decaying cells in the output PGM are checked.
+func TestGenerations(t *testing.T) {
+	rules := []string{"B2/S/C3", "B2/S345/C4"}
+	for _, rule := range rules {
+		for _, turns := range []int{1, 100} {
+			p := gol.Params{ImageWidth: 100, ImageHeight: 75, Turns: turns, Rule: rule}
+			golden := fmt.Sprintf("check/images/%vx%vx%v-%s.pgm", p.ImageWidth, p.ImageHeight, turns, strings.Replace(rule, "/", "", -1))
+			expected := readCellValues(golden, p.ImageWidth, p.ImageHeight)
+			var expectedAlive []util.Cell
+			for y := range expected {
+				for x := range expected[y] {
+					if expected[y][x] == 255 {
+						expectedAlive = append(expectedAlive, util.Cell{X: x, Y: y})
+					}
+				}
+			}
+			for _, threads := range []int{1, 4, 8} {
+				p.Threads = threads
+				testName := fmt.Sprintf("%s-%dx%dx%d-%d", strings.Replace(rule, "/", "", -1), p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+				t.Run(testName, func(t *testing.T) {
+					events := make(chan gol.Event)
+					go gol.Run(p, events, nil)
+					var cells []util.Cell
+					for event := range events {
+						switch e := event.(type) {
+						case gol.FinalTurnComplete:
+							cells = e.Alive
+						}
+					}
+					assertEqualBoard(t, cells, expectedAlive, p)
+					output := readCellValues(fmt.Sprintf("out/%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns), p.ImageWidth, p.ImageHeight)
+					for y := range expected {
+						for x := range expected[y] {
+							if output[y][x] != expected[y][x] {
+								t.Fatalf("Output PGM has value %v at (%v, %v), expected %v", output[y][x], x, y, expected[y][x])
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// readCellValues reads the raw values of a PGM image, including grey levels.
+func readCellValues(path string, width, height int) [][]uint8 {
+	data, ioError := ioutil.ReadFile(path)
+	util.Check(ioError)
+
+	fields := strings.SplitN(string(data), "\n", 4)
+	size := strings.Fields(fields[1])
+	imageWidth, _ := strconv.Atoi(size[0])
+	imageHeight, _ := strconv.Atoi(size[1])
+	if fields[0] != "P5" || imageWidth != width || imageHeight != height || fields[2] != "255" {
+		panic("Incorrect pgm header")
+	}
+
+	image := []byte(fields[3])
+	values := make([][]uint8, height)
+	for y := range values {
+		values[y] = image[y*width : (y+1)*width]
+	}
+	return values
+}
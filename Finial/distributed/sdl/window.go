@@ -87,6 +87,19 @@ func (w *Window) FlipPixel(x, y int) {
 	w.pixels[4*(y*width+x)+3] = ^w.pixels[4*(y*width+x)+3]
 }
 
+// SetPixelValue sets a pixel to a grey level, keeping 0 identical to an unset pixel so FlipPixel still works.
+func (w *Window) SetPixelValue(x, y int, value uint8) {
+	width := int(w.Width)
+	alpha := uint8(0xFF)
+	if value == 0 {
+		alpha = 0
+	}
+	w.pixels[4*(y*width+x)+0] = value
+	w.pixels[4*(y*width+x)+1] = value
+	w.pixels[4*(y*width+x)+2] = value
+	w.pixels[4*(y*width+x)+3] = alpha
+}
+
 func (w *Window) CountPixels() int {
 	count := 0
 	for i := 0; i < int(w.Width) * int(w.Height) * 4; i += 4 {
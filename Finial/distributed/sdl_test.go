@@ -59,6 +59,13 @@ sdlLoop:
 				if w != nil {
 					w.FlipPixel(e.Cell.X, e.Cell.Y)
 				}
+			case gol.CellsFlipped:
+				for _, cell := range e.Cells {
+					board[cell.Y][cell.X] = ^board[cell.Y][cell.X]
+					if w != nil {
+						w.FlipPixel(cell.X, cell.Y)
+					}
+				}
 			case gol.TurnComplete:
 				if w != nil {
 					w.RenderFrame()
@@ -104,6 +111,8 @@ func TestSdl(t *testing.T) {
 			switch e := event.(type) {
 			case gol.CellFlipped:
 				sdlEvents <- e
+			case gol.CellsFlipped:
+				sdlEvents <- e
 			case gol.TurnComplete:
 				turnNum++
 				sdlEvents <- e
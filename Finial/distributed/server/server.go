@@ -1,12 +1,11 @@
-package main
+package server
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/rpc"
-	"os"
 	"sync"
 	"time"
 	"uk.ac.bris.cs/gameoflife/goUtils"
@@ -14,42 +13,46 @@ import (
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
-func extractRows(world [][]uint8, startRow, endRow int) [][]uint8 {
-	var extracted [][]uint8
-	for i := startRow; i < endRow; i++ {
-		if i < len(world) {
-			extracted = append(extracted, world[i])
-		}
-	}
-	return extracted
+func nextCellState(aliveNeighbors int, currentState uint8, rule util.Rule) uint8 {
+	// Birth, survival and decay come from the rule, B3/S23 by default
+	return rule.NextState(currentState, aliveNeighbors)
+}
+
+// strip is the part of the world a server owns, rows startY to endY, together with
+// the rows just above and below it that belong to other servers. On the projective
+// plane a cell at a side edge borders the mirrored row, so the edge columns of the
+// whole board are kept too.
+type strip struct {
+	startY, endY int
+	height       int
+	rows         [][]uint8
+	above, below []uint8
+	left, right  []uint8
 }
-func nextCellState(aliveNeighbors int, currentState uint8) uint8 {
-	// Rules for cell state in the Game of Life
+
+// cell returns the value of cell (x, y), which has to be in or next to the strip.
+func (st strip) cell(x, y int) uint8 {
 	switch {
-	case currentState == 255 && aliveNeighbors < 2:
-		return 0 // Underpopulation
-	case currentState == 255 && (aliveNeighbors == 2 || aliveNeighbors == 3):
-		return 255 // Lives on
-	case currentState == 255 && aliveNeighbors > 3:
-		return 0 // Overpopulation
-	case currentState == 0 && aliveNeighbors == 3:
-		return 255 // Reproduction
+	case y >= st.startY && y < st.endY:
+		return st.rows[y-st.startY][x]
+	case y == (st.startY-1+st.height)%st.height:
+		return st.above[x]
+	case y == st.endY%st.height:
+		return st.below[x]
+	case x == 0:
+		return st.left[y]
 	default:
-		return currentState
+		return st.right[y]
 	}
 }
 
-func countAliveNeighbors(x, y int, p goUtils.Params, world [][]uint8) int {
+func countAliveNeighbors(x, y int, p goUtils.Params, st strip, topology util.Topology) int {
 	alive := 0
 	for i := -1; i <= 1; i++ {
 		for j := -1; j <= 1; j++ {
 			if !(i == 0 && j == 0) {
-				//neighborX := (x + j + p.ImageWidth) % p.ImageWidth
-				//neighborY := (y + i + len(world)) % len(world)
-
-				neighborX := (x + j + p.ImageWidth) & (p.ImageWidth - 1)
-				neighborY := (y + i + len(world)) & (len(world) - 1)
-				if world[neighborY][neighborX] != 0 {
+				neighborX, neighborY, ok := topology.Neighbour(x+j, y+i, p.ImageWidth, p.ImageHeight)
+				if ok && st.cell(neighborX, neighborY) == 255 {
 					alive++
 				}
 			}
@@ -66,27 +69,68 @@ func CreateNewWorld(height, width int) [][]byte {
 	return newWorld
 }
 
-func calculateNextState(p goUtils.Params, startY, endY, startX, endX int, world [][]uint8, turn int) ([][]uint8, []stubs.CellStateChange) {
-	newWorld := CreateNewWorld(endY-startY, p.ImageWidth)
-	var stateChanges []stubs.CellStateChange
+// tileSize is the width and height of the tiles used to skip regions that did not change.
+const tileSize = 32
 
-	if endY > len(world) {
-		endY = len(world)
-	}
+func tileCount(p goUtils.Params) (rows, cols int) {
+	return (p.ImageHeight + tileSize - 1) / tileSize, (p.ImageWidth + tileSize - 1) / tileSize
+}
 
-	for y := startY; y < endY; y++ {
-		for x := startX; x < endX; x++ {
-			aliveNeighbors := countAliveNeighbors(x, y, p, world)
-			newState := nextCellState(aliveNeighbors, world[y][x])
-			newWorld[y-startY][x] = newState
-			if newState != world[y][x] {
-				stateChange := stubs.CellStateChange{Cell: util.Cell{X: y, Y: x}, Turn: turn}
-				stateChanges = append(stateChanges, stateChange)
+// calculateNextState computes the next turn of the strip. Tiles that are not
+// active are copied, since nothing near them changed last turn, and tiles with a
+// cell that changed are marked in changed.
+func calculateNextState(p goUtils.Params, st strip, rule util.Rule, topology util.Topology, active, changed []bool) [][]uint8 {
+	newWorld := CreateNewWorld(st.endY-st.startY, p.ImageWidth)
+	_, cols := tileCount(p)
+
+	for y := st.startY; y < st.endY; y++ {
+		row := st.rows[y-st.startY]
+		for tileX := 0; tileX < p.ImageWidth; tileX += tileSize {
+			tileEndX := tileX + tileSize
+			if tileEndX > p.ImageWidth {
+				tileEndX = p.ImageWidth
+			}
+			tile := (y/tileSize)*cols + tileX/tileSize
+			if !active[tile] {
+				copy(newWorld[y-st.startY][tileX:tileEndX], row[tileX:tileEndX])
+				continue
+			}
+			for x := tileX; x < tileEndX; x++ {
+				aliveNeighbors := countAliveNeighbors(x, y, p, st, topology)
+				newState := nextCellState(aliveNeighbors, row[x], rule)
+				newWorld[y-st.startY][x] = newState
+				if newState != row[x] {
+					changed[tile] = true
+				}
 			}
 		}
 	}
 
-	return newWorld, stateChanges
+	return newWorld
+}
+
+// markHaloChanges marks the tiles of the cells outside the strip that changed
+// since the last turn, so that the strip's tiles next to them are recomputed.
+func markHaloChanges(p goUtils.Params, previous, current strip, changed []bool) {
+	_, cols := tileCount(p)
+	aboveY := (current.startY - 1 + p.ImageHeight) % p.ImageHeight
+	belowY := current.endY % p.ImageHeight
+	for x := 0; x < p.ImageWidth; x++ {
+		if previous.above[x] != current.above[x] {
+			changed[(aboveY/tileSize)*cols+x/tileSize] = true
+		}
+		if previous.below[x] != current.below[x] {
+			changed[(belowY/tileSize)*cols+x/tileSize] = true
+		}
+	}
+	for y := range current.left {
+		if previous.left[y] != current.left[y] {
+			changed[(y/tileSize)*cols] = true
+		}
+		if previous.right[y] != current.right[y] {
+			changed[(y/tileSize)*cols+cols-1] = true
+		}
+	}
 }
 
 func countCell(world [][]uint8) int {
@@ -101,152 +145,305 @@ func countCell(world [][]uint8) int {
 	return counter
 }
 
-type Server struct {
-	params       goUtils.Params
-	world        [][]uint8
-	turn         int
-	startRow     int
-	endRow       int
-	stateChanges []stubs.CellStateChange
-	isPause      bool
-	isShotdown   bool
-	dataLock     sync.Mutex
+// session is the server's part of one world. A server can work on several
+// worlds at once, each with its own strip and neighbours.
+type session struct {
+	params   goUtils.Params
+	rule     util.Rule
+	topology util.Topology
+	strip    strip
+	turn     int
+	changed  []bool // tiles that changed in the last turn, nil before the first
+
+	// The servers owning the rows above and below the strip, and the rows they sent.
+	above, below         *rpc.Client
+	fromAbove, fromBelow chan halo
+	dataLock             sync.Mutex
 }
 
-func (s *Server) ShotDown(req *stubs.Request, res *stubs.Response) (err error) {
-	done := make(chan bool, 1)
-	go func() {
-		fmt.Println("Server is shutting down...")
-		time.Sleep(time.Second)
-		done <- true
-	}()
-	<-done
-	s.isShotdown = true
-	os.Exit(0)
-	return nil
+// halo is a row sent by a neighbouring server: row y of the world at the start of
+// turn. A row sent for a turn that failed can arrive after the strip is reloaded,
+// so rows of another turn or row are dropped.
+type halo struct {
+	turn, y int
+	row     []uint8
 }
 
-func (s *Server) DisconnectClient(req *stubs.Request, res *stubs.Response) (err error) {
-
-	fmt.Println("Client disconnected")
+type Server struct {
+	sessions     map[int]*session
+	sessionsLock sync.Mutex
 
-	return
+	// ctx is done once the server is shutting down, and shutDown makes it so.
+	ctx      context.Context
+	shutDown context.CancelFunc
 }
 
-func (s *Server) UnPause(req *stubs.Request, res *stubs.Response) (err error) {
-
-	s.isPause = false
-	fmt.Println("UnPause")
-	return
+// Serve runs a server on listener until ctx is done or the broker calls ShotDown.
+// A turn being computed when it stops fails, and the server's sessions are dropped.
+func Serve(ctx context.Context, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &Server{sessions: make(map[int]*session), ctx: ctx, shutDown: cancel}
+	err := stubs.Serve(ctx, listener, s)
+	s.sessionsLock.Lock()
+	for id, ss := range s.sessions {
+		delete(s.sessions, id)
+		ss.close()
+	}
+	s.sessionsLock.Unlock()
+	return err
 }
 
-func (s *Server) Pause(req *stubs.Request, res *stubs.Response) (err error) {
-
-	s.isPause = true
+// session returns the session with the given ID. If create is set a session
+// that does not exist yet is made.
+func (s *Server) session(id int, create bool) (*session, error) {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+	ss, ok := s.sessions[id]
+	if !ok && !create {
+		return nil, fmt.Errorf("no session %d", id)
+	}
+	if !ok {
+		// Neighbours cannot start the next turn before this server has finished the
+		// current one, so at most one row from each side is ever waiting.
+		ss = &session{
+			fromAbove: make(chan halo, 1),
+			fromBelow: make(chan halo, 1),
+		}
+		s.sessions[id] = ss
+	}
+	return ss, nil
+}
 
-	fmt.Println("Pause")
-	return
+// ShotDown stops the server once it has replied.
+func (s *Server) ShotDown(req *stubs.Request, res *stubs.Response) (err error) {
+	fmt.Println("Server is shutting down...")
+	s.shutDown()
+	return nil
 }
 
 func (s *Server) SendCurrentState(req *stubs.Request, res *stubs.Response) (err error) {
-
-	s.dataLock.Lock()
-	res.World = s.world
-	res.Turn = s.turn
-	s.dataLock.Unlock()
+	ss, err := s.session(req.Session, false)
+	if err != nil {
+		return err
+	}
+	ss.dataLock.Lock()
+	res.World = ss.strip.rows
+	res.Turn = ss.turn
+	res.StartRow = ss.strip.startY
+	res.EndRow = ss.strip.endY
+	ss.dataLock.Unlock()
 	fmt.Println("Sending Current State...")
 	return
 }
-func (s *Server) SendCellNumber(req *stubs.Request, res *stubs.Response) (err error) {
-
-	num := countCell(s.world)
-
-	//fmt.Println("Sending cell number for specified rows...")
-	res.Cellnum = num
-	res.Turn = s.turn
 
+// Ping answers the broker's heartbeats. It does not take any lock, so a server
+// in the middle of a turn still answers.
+func (s *Server) Ping(req *stubs.Request, res *stubs.Response) (err error) {
 	return
 }
 
-func (s *Server) SendCellFlip(req *stubs.Request, res *stubs.Response) (err error) {
+// LoadStrip gives the server rows StartRow to EndRow of the world of session
+// Session after turn Turn to evolve and connects it to the servers above and
+// below, which may be the server itself.
+func (s *Server) LoadStrip(req *stubs.Request, res *stubs.Response) (err error) {
 
-	fmt.Println("SendCellFlip....")
-	s.dataLock.Lock()
-	res.StateChanges = s.stateChanges
-	res.Turn = s.turn
-	s.dataLock.Unlock()
+	fmt.Println("Loading...")
+	rule, err := util.ParseRule(req.Params.Rule)
+	if err != nil {
+		return err
+	}
+	topology, err := util.ParseTopology(req.Params.Topology)
+	if err != nil {
+		return err
+	}
+	if req.StartRow < 0 || req.EndRow > req.Params.ImageHeight || req.StartRow >= req.EndRow || len(req.World) != req.EndRow-req.StartRow {
+		return fmt.Errorf("invalid strip %d to %d of %d rows", req.StartRow, req.EndRow, req.Params.ImageHeight)
+	}
+	above, err := rpc.Dial("tcp", req.AboveAddr)
+	if err != nil {
+		return err
+	}
+	below, err := rpc.Dial("tcp", req.BelowAddr)
+	if err != nil {
+		above.Close()
+		return err
+	}
 
+	ss, _ := s.session(req.Session, true)
+	ss.dataLock.Lock()
+	ss.params = req.Params
+	ss.rule = rule
+	ss.topology = topology
+	ss.strip = strip{startY: req.StartRow, endY: req.EndRow, height: req.Params.ImageHeight, rows: req.World}
+	ss.turn = req.Turn
+	ss.changed = nil
+	if ss.above != nil {
+		ss.above.Close()
+		ss.below.Close()
+	}
+	ss.above, ss.below = above, below
+	// Drop any rows left over from a turn that failed.
+	for len(ss.fromAbove) > 0 {
+		<-ss.fromAbove
+	}
+	for len(ss.fromBelow) > 0 {
+		<-ss.fromBelow
+	}
+	ss.dataLock.Unlock()
 	return
 }
 
-func (s *Server) Update(req *stubs.Request, res *stubs.Response) (err error) {
+// EndSession drops the server's part of a world that has finished.
+func (s *Server) EndSession(req *stubs.Request, res *stubs.Response) (err error) {
+	ss, err := s.session(req.Session, false)
+	if err != nil {
+		return err
+	}
+	s.sessionsLock.Lock()
+	delete(s.sessions, req.Session)
+	s.sessionsLock.Unlock()
+	ss.close()
+	return
+}
 
-	fmt.Println("Loading...")
-	s.dataLock.Lock()
-	s.world = req.World
-	s.startRow = req.StartRow
-	s.endRow = req.EndRow
-	s.params = req.Params
-	s.stateChanges = []stubs.CellStateChange{}
-	s.turn = 0
-
-	s.dataLock.Unlock()
-
-	fmt.Println("Updating ...")
-	params := s.params
-	world := s.world
-
-	for s.turn < params.Turns && !s.isShotdown {
-
-		s.dataLock.Lock()
-		if s.isPause {
-			s.dataLock.Unlock()
-			//wait isPause become false
-			for {
-				s.dataLock.Lock()
-				if !s.isPause {
-					s.dataLock.Unlock()
-					break
-				}
-				s.dataLock.Unlock()
+// close hangs up on the session's neighbours.
+func (ss *session) close() {
+	ss.dataLock.Lock()
+	if ss.above != nil {
+		ss.above.Close()
+		ss.below.Close()
+	}
+	ss.dataLock.Unlock()
+}
 
-				time.Sleep(100 * time.Millisecond)
-			}
-		} else {
-			s.dataLock.Unlock()
+// ReceiveHalo takes row req.HaloY from the server above or below for turn req.Turn.
+// It does not take the session's dataLock, which Step holds while it waits for the rows.
+func (s *Server) ReceiveHalo(req *stubs.Request, res *stubs.Response) (err error) {
+	ss, err := s.session(req.Session, false)
+	if err != nil {
+		return err
+	}
+	if req.HaloAbove != nil {
+		select {
+		case ss.fromAbove <- halo{req.Turn, req.HaloY, req.HaloAbove}:
+		case <-s.ctx.Done():
+			return errors.New("server is shutting down")
 		}
-
-		var changes []stubs.CellStateChange
-		world, changes = calculateNextState(params, 0, params.ImageHeight, 0, params.ImageWidth, world, s.turn)
-		s.stateChanges = append(s.stateChanges, changes...)
-		s.turn++
-		s.world = world
-
 	}
+	if req.HaloBelow != nil {
+		select {
+		case ss.fromBelow <- halo{req.Turn, req.HaloY, req.HaloBelow}:
+		case <-s.ctx.Done():
+			return errors.New("server is shutting down")
+		}
+	}
+	return
+}
 
-	res.Turn = s.turn
-
-	res.World = extractRows(s.world, s.startRow, s.endRow)
-
-	res.StartRow = s.startRow
-	res.EndRow = s.endRow
+// haloTimeout is how long a server waits for its neighbours before it gives up on the turn.
+const haloTimeout = 5 * time.Second
+
+// exchangeHalos sends the strip's top row to the server above and its bottom row
+// to the server below for turn, then waits for their rows. It fails if a neighbour
+// has died or does not answer within haloTimeout, or if ctx is done.
+func (ss *session) exchangeHalos(ctx context.Context, id, turn int) (above, below []uint8, err error) {
+	st := ss.strip
+	rows := st.rows
+	toAbove := ss.above.Go(stubs.ReceiveHalo, &stubs.Request{Session: id, Turn: turn, HaloY: st.startY, HaloBelow: rows[0]}, new(stubs.Response), nil)
+	toBelow := ss.below.Go(stubs.ReceiveHalo, &stubs.Request{Session: id, Turn: turn, HaloY: st.endY - 1, HaloAbove: rows[len(rows)-1]}, new(stubs.Response), nil)
+	timeout := time.After(haloTimeout)
+	// The rows are taken before waiting for the sends, as a neighbour's send can
+	// only finish once the row left over from a failed turn has been dropped here.
+	above, err = receiveHalo(ctx, ss.fromAbove, turn, (st.startY-1+st.height)%st.height, timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("row above: %v", err)
+	}
+	below, err = receiveHalo(ctx, ss.fromBelow, turn, st.endY%st.height, timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("row below: %v", err)
+	}
+	for _, call := range []*rpc.Call{toAbove, toBelow} {
+		select {
+		case <-call.Done:
+			if call.Error != nil {
+				return nil, nil, call.Error
+			}
+		case <-timeout:
+			return nil, nil, errors.New("timed out sending halo rows")
+		case <-ctx.Done():
+			return nil, nil, errors.New("server is shutting down")
+		}
+	}
+	if len(above) != len(rows[0]) || len(below) != len(rows[0]) {
+		return nil, nil, errors.New("halo rows do not match the strip")
+	}
+	return above, below, nil
+}
 
-	return
+// receiveHalo waits for row y for turn, dropping any other rows.
+func receiveHalo(ctx context.Context, rows <-chan halo, turn, y int, timeout <-chan time.Time) ([]uint8, error) {
+	for {
+		select {
+		case h := <-rows:
+			if h.turn == turn && h.y == y {
+				return h.row, nil
+			}
+		case <-timeout:
+			return nil, errors.New("timed out waiting for the row")
+		case <-ctx.Done():
+			return nil, errors.New("server is shutting down")
+		}
+	}
 }
 
-func main() {
-	pAddr := flag.String("port", "8036", "Port to listen on")
-	flag.Parse()
-	worker := new(Server)
-	err := rpc.Register(worker)
+// Step swaps halo rows with the neighbouring servers, computes one turn of the
+// session's strip and returns the number of alive cells in it. The strip itself
+// stays on the server until the broker asks for it with SendCurrentState.
+func (s *Server) Step(req *stubs.Request, res *stubs.Response) (err error) {
+	ss, err := s.session(req.Session, false)
 	if err != nil {
-		log.Fatalf("Error registering service: %v", err)
+		return err
 	}
-	listener, err := net.Listen("tcp", ":"+*pAddr)
+	ss.dataLock.Lock()
+	defer ss.dataLock.Unlock()
+	if ss.strip.rows == nil {
+		return errors.New("no strip loaded")
+	}
+
+	previous := ss.strip
+	ss.strip.above, ss.strip.below, err = ss.exchangeHalos(s.ctx, req.Session, req.Turn)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return err
 	}
-	fmt.Println("listening on %s", listener.Addr().String())
-	defer listener.Close()
-	rpc.Accept(listener)
+	ss.strip.left, ss.strip.right = req.LeftColumn, req.RightColumn
+
+	// Every tile is computed in the first turn, after that only tiles near a change.
+	tileRows, tileCols := tileCount(ss.params)
+	active := make([]bool, tileRows*tileCols)
+	if ss.changed == nil {
+		for tile := range active {
+			active[tile] = true
+		}
+	} else {
+		markHaloChanges(ss.params, previous, ss.strip, ss.changed)
+		active = util.ActiveTiles(ss.changed, tileRows, tileCols, ss.topology)
+	}
+
+	ss.changed = make([]bool, len(active))
+	rows := calculateNextState(ss.params, ss.strip, ss.rule, ss.topology, active, ss.changed)
+	ss.strip.rows = rows
+	ss.turn = req.Turn
+
+	res.Turn = ss.turn
+	res.Cellnum = countCell(rows)
+	if ss.strip.left != nil {
+		res.LeftColumn = make([]uint8, len(rows))
+		res.RightColumn = make([]uint8, len(rows))
+		for y, row := range rows {
+			res.LeftColumn[y] = row[0]
+			res.RightColumn[y] = row[len(row)-1]
+		}
+	}
+	return
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/goUtils"
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestSessions runs the 512x512 image on the torus and the 100x75 image on the Klein bottle for
+// 100 turns at the same time on a broker on port 8100 with 2 servers, then kills a third session
+// and hangs up on a fourth.
+func TestSessions(t *testing.T) {
+	brokerAddr, stop := startProcessCluster(t, 8100, 2)
+	defer stop()
+
+	tests := []gol.Params{
+		{ImageWidth: 512, ImageHeight: 512, Turns: 100, BrokerAddr: brokerAddr},
+		{ImageWidth: 100, ImageHeight: 75, Turns: 100, Topology: "klein", BrokerAddr: brokerAddr},
+	}
+	var wg sync.WaitGroup
+	for _, p := range tests {
+		name := fmt.Sprintf("%vx%vx%v", p.ImageWidth, p.ImageHeight, p.Turns)
+		if p.Topology != "" {
+			name += "-" + p.Topology
+		}
+		expectedAlive := readAliveCells("check/images/"+name+".pgm", p.ImageWidth, p.ImageHeight)
+		wg.Add(1)
+		go func(p gol.Params) {
+			defer wg.Done()
+			assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+		}(p)
+	}
+	wg.Wait()
+
+	client, err := rpc.Dial("tcp", brokerAddr)
+	util.Check(err)
+	defer client.Close()
+	res := new(stubs.Response)
+	util.Check(client.Call(stubs.ListSessions, stubs.Request{}, res))
+	if len(res.Sessions) != 0 {
+		t.Fatalf("Expected the finished sessions to be forgotten, but there are %d sessions", len(res.Sessions))
+	}
+
+	// A session that would run for ever stops when it is killed.
+	req := stubs.Request{
+		World:  readCellValues("images/64x64.pgm", 64, 64),
+		Params: goUtils.Params{Turns: 10000000000, ImageWidth: 64, ImageHeight: 64},
+	}
+	loaded := new(stubs.Response)
+	util.Check(client.Call(stubs.LoadWorldToBroker, req, loaded))
+	call := client.Go(stubs.CallServerProcessWorld, stubs.Request{Session: loaded.Session}, new(stubs.Response), nil)
+	var running stubs.SessionInfo
+	for running.Turn == 0 {
+		time.Sleep(10 * time.Millisecond)
+		res := new(stubs.Response)
+		util.Check(client.Call(stubs.ListSessions, stubs.Request{}, res))
+		running = res.Sessions[len(res.Sessions)-1]
+	}
+	if running.Finished {
+		t.Fatalf("Expected session %d to be running", running.ID)
+	}
+	util.Check(client.Call(stubs.KillSession, stubs.Request{Session: running.ID}, new(stubs.Response)))
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			t.Errorf("Expected the killed session to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The killed session did not stop")
+	}
+	res = new(stubs.Response)
+	util.Check(client.Call(stubs.ListSessions, stubs.Request{}, res))
+	if len(res.Sessions) != 0 {
+		t.Errorf("Expected the killed session to be forgotten, but there are %d sessions", len(res.Sessions))
+	}
+
+	// A session whose controller hangs up without detaching is killed.
+	controller, err := rpc.Dial("tcp", brokerAddr)
+	util.Check(err)
+	util.Check(controller.Call(stubs.LoadWorldToBroker, req, loaded))
+	controller.Go(stubs.CallServerProcessWorld, stubs.Request{Session: loaded.Session}, new(stubs.Response), nil)
+	for {
+		res := new(stubs.Response)
+		util.Check(client.Call(stubs.GetSession, stubs.Request{Session: loaded.Session}, res))
+		if res.Turn > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	controller.Close()
+	forgotten := false
+	for start := time.Now(); !forgotten && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		forgotten = client.Call(stubs.GetSession, stubs.Request{Session: loaded.Session}, new(stubs.Response)) != nil
+	}
+	if !forgotten {
+		t.Errorf("Expected session %d to be killed once its controller hung up", loaded.Session)
+	}
+
+	// A controller whose session is killed ends its game instead of the whole program.
+	events := make(chan gol.Event, 1000)
+	go gol.Run(gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 10000000000, BrokerAddr: brokerAddr}, events, nil)
+	quitting := make(chan bool)
+	go func() {
+		last := false
+		for event := range events {
+			if e, ok := event.(gol.StateChange); ok {
+				last = e.NewState == gol.Quitting
+			}
+		}
+		quitting <- last
+	}()
+	running = stubs.SessionInfo{}
+	for running.Turn == 0 || running.Finished {
+		time.Sleep(10 * time.Millisecond)
+		res := new(stubs.Response)
+		util.Check(client.Call(stubs.ListSessions, stubs.Request{}, res))
+		if len(res.Sessions) > 0 {
+			running = res.Sessions[len(res.Sessions)-1]
+		}
+	}
+	util.Check(client.Call(stubs.KillSession, stubs.Request{Session: running.ID}, new(stubs.Response)))
+	select {
+	case last := <-quitting:
+		if !last {
+			t.Errorf("Expected the killed game to end with Quitting")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The controller of the killed session did not stop")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestShutdown starts a broker on port 8120 and 2 servers in this process, plays the 512x512
+// image and stops it with k or q once the first alive cells are counted. Pressing k has to stop
+// the whole stack; after q the broker and servers are stopped by cancelling them. The ports are
+// used again straight after, so every listener has to have been closed.
+func TestShutdown(t *testing.T) {
+	for _, key := range []rune{'k', 'q', 'k', 'q'} {
+		brokerAddr, stop := startLocalCluster(8120, 2)
+
+		p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 10000000000, BrokerAddr: brokerAddr}
+		events := make(chan gol.Event)
+		keyPresses := make(chan rune, 1)
+		ran := make(chan bool)
+		go func() {
+			gol.Run(p, events, keyPresses)
+			close(ran)
+		}()
+		pressed := false
+		var last gol.Event
+		for event := range events {
+			if _, ok := event.(gol.AliveCellsCount); ok && !pressed {
+				keyPresses <- key
+				pressed = true
+			}
+			last = event
+		}
+		if state, ok := last.(gol.StateChange); !ok || state.NewState != gol.Quitting {
+			t.Errorf("Expected the last event after %c to be Quitting, got %v", key, last)
+		}
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("gol.Run did not return after %c", key)
+		}
+
+		// After k the stack closes its listeners by itself, before it is cancelled.
+		if key == 'k' {
+			for _, addr := range []string{"127.0.0.1:8120", "127.0.0.1:8121", "127.0.0.1:8122"} {
+				if !waitClosed(addr, 5*time.Second) {
+					t.Fatalf("%s was still listening after %c", addr, key)
+				}
+			}
+		}
+		stopped := make(chan error)
+		go func() {
+			stopped <- stop()
+		}()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("Expected the stack to stop cleanly after %c, got %v", key, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The broker and servers did not stop after %c", key)
+		}
+	}
+}
+
+// waitClosed reports whether addr stops accepting connections within timeout.
+func waitClosed(addr string, timeout time.Duration) bool {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+	}
+	return false
+}
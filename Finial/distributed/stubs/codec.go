@@ -0,0 +1,116 @@
+package stubs
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"sync"
+)
+
+// HangUpWatcher is implemented by a service that wants to know when a controller
+// hangs up while it waits for its session in CallServerProcessWorld or
+// WaitForSession, without calling DetachClient first.
+type HangUpWatcher interface {
+	HungUp(session int)
+}
+
+// watchCodec is the gob codec net/rpc uses, but it keeps the sessions being waited
+// for on its connection and hands them to hungUp once the connection is closed.
+type watchCodec struct {
+	conn   io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+	hungUp func(session int)
+
+	// The method and sequence number of the request being read.
+	method string
+	seq    uint64
+
+	lock     sync.Mutex
+	waiting  map[uint64]int // the session of each waiting call, by sequence number
+	detached map[int]bool
+}
+
+func newWatchCodec(conn io.ReadWriteCloser, hungUp func(session int)) *watchCodec {
+	buf := bufio.NewWriter(conn)
+	return &watchCodec{
+		conn:     conn,
+		dec:      gob.NewDecoder(conn),
+		enc:      gob.NewEncoder(buf),
+		encBuf:   buf,
+		hungUp:   hungUp,
+		waiting:  make(map[uint64]int),
+		detached: make(map[int]bool),
+	}
+}
+
+func (c *watchCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.dec.Decode(r)
+	if err != nil {
+		// The caller has gone; net/rpc still answers the calls in progress.
+		c.lock.Lock()
+		var sessions []int
+		for _, session := range c.waiting {
+			if !c.detached[session] {
+				sessions = append(sessions, session)
+			}
+		}
+		c.waiting = make(map[uint64]int)
+		c.lock.Unlock()
+		if c.hungUp != nil {
+			for _, session := range sessions {
+				c.hungUp(session)
+			}
+		}
+		return err
+	}
+	c.method = r.ServiceMethod
+	c.seq = r.Seq
+	return nil
+}
+
+func (c *watchCodec) ReadRequestBody(body interface{}) error {
+	err := c.dec.Decode(body)
+	req, ok := body.(*Request)
+	if err != nil || !ok || req.Session == 0 {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch c.method {
+	case CallServerProcessWorld, WaitForSession:
+		c.waiting[c.seq] = req.Session
+		delete(c.detached, req.Session)
+	case DetachClient:
+		c.detached[req.Session] = true
+	}
+	return nil
+}
+
+func (c *watchCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.lock.Lock()
+	delete(c.waiting, r.Seq)
+	c.lock.Unlock()
+	err := c.enc.Encode(r)
+	if err == nil {
+		err = c.enc.Encode(body)
+	}
+	if err != nil {
+		// The connection is broken once part of a reply has been written.
+		c.encBuf.Flush()
+		c.Close()
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *watchCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,78 @@
+package stubs
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout is how long Serve waits, once ctx is done, for its callers to hang up.
+const shutdownTimeout = time.Second
+
+// Serve answers RPC calls to service on listener until ctx is done. It then closes
+// listener, lets the calls in progress reply and waits up to shutdownTimeout for
+// the callers to hang up before it closes their connections itself. A service that
+// is a HangUpWatcher is told about the controllers that hang up.
+func Serve(ctx context.Context, listener net.Listener, service interface{}) error {
+	server := rpc.NewServer()
+	err := server.Register(service)
+	if err != nil {
+		return err
+	}
+	var hungUp func(session int)
+	if watcher, ok := service.(HangUpWatcher); ok {
+		hungUp = watcher.HungUp
+	}
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		listener.Close()
+	}()
+
+	var connsLock sync.Mutex
+	conns := make(map[net.Conn]bool)
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		connsLock.Lock()
+		conns[conn] = true
+		connsLock.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.ServeCodec(newWatchCodec(conn, hungUp))
+			connsLock.Lock()
+			delete(conns, conn)
+			connsLock.Unlock()
+		}()
+	}
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		connsLock.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		connsLock.Unlock()
+		<-done
+	}
+	return nil
+}
@@ -1,48 +1,106 @@
 package stubs
 
 import (
+	"time"
+
 	"uk.ac.bris.cs/gameoflife/goUtils"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type Request struct {
+	// The world a call is about. Broker calls that look up a session take 0 to
+	// mean the newest one; CallServerProcessWorld starts a new session with it.
+	Session int
+
 	Params   goUtils.Params
 	World    [][]uint8
 	StartRow int
 	EndRow   int
+
+	// RegisterServer and DeregisterServer: the address of the server.
+	ServerAddr string
+
+	// LoadStrip: the servers that own the rows just above and below the strip.
+	AboveAddr, BelowAddr string
+
+	// Step: the turn to compute. The edge columns of the whole board are only
+	// sent on the projective plane. LoadStrip, LoadWorldToBroker and
+	// CallServerProcessWorld: the turn the world is from. AdvanceSession: the
+	// turn to run a paused session on to.
+	Turn                    int
+	LeftColumn, RightColumn []uint8
+
+	// ReceiveHalo: row HaloY of the world at the start of turn Turn, sent by the
+	// server above or below.
+	HaloAbove, HaloBelow []uint8
+	HaloY                int
+
+	// SetTurnDelay: the time the broker waits after each turn.
+	Delay time.Duration
 }
 
 type Response struct {
-	Turn         int
-	World        [][]uint8
-	Cellnum      int
-	Message      string
-	StartRow     int
-	EndRow       int
+	Session  int
+	Params   goUtils.Params
+	Turn     int
+	World    [][]uint8
+	Cellnum  int
+	Message  string
+	StartRow int
+	EndRow   int
+
+	// AggregateCellFlip: the cells that changed since the controller last asked.
+	// CallServerProcessWorld and WaitForSession: the last of them, as the
+	// session is forgotten once its controller has its world.
 	StateChanges []CellStateChange
+
+	// Step: on the projective plane, the strip's part of the edge columns.
+	LeftColumn, RightColumn []uint8
+
+	// ListSessions: every session on the broker.
+	Sessions []SessionInfo
+}
+
+// SessionInfo describes one of the worlds on the broker.
+type SessionInfo struct {
+	ID       int
+	Params   goUtils.Params
+	Turn     int
+	Attached bool // a controller is collecting the cells that change
+	Paused   bool
+	Finished bool // only kept until a controller attaches and collects the world
 }
 
 type CellStateChange struct {
-	Cell util.Cell
-	Turn int
+	Cell     util.Cell
+	Turn     int
+	Previous uint8
+	Value    uint8
 }
 
-var LoadWorld = "Server.LoadWorld"
-var Update = "Server.Update"
-var SendCellNumber = "Server.SendCellNumber"
+var LoadStrip = "Server.LoadStrip"
+var Step = "Server.Step"
+var ReceiveHalo = "Server.ReceiveHalo"
+var Ping = "Server.Ping"
 var SendCurrentState = "Server.SendCurrentState"
-var Pause = "Server.Pause"
-var UnPause = "Server.UnPause"
-var DisconnectClient = "Server.DisconnectClient"
 var ShotDown = "Server.ShotDown"
-var SendCellFlip = "Server.SendCellFlip"
+var EndSession = "Server.EndSession"
 
 var LoadWorldToBroker = "Broker.LoadWorldToBroker"
 var CallServerProcessWorld = "Broker.CallServerProcessWorld"
 var AggregateCellNumbers = "Broker.AggregateCellNumbers"
 var AggregateCurrentState = "Broker.AggregateCurrentState"
-var DisconnectAllServers = "Broker.DisconnectAllServers"
 var PauseAllServers = "Broker.PauseAllServers"
 var UnPauseAllServers = "Broker.UnPauseAllServers"
 var ShutDownAllServers = "Broker.ShutDownAllServers"
 var AggregateCellFlip = "Broker.AggregateCellFlip"
+var RegisterServer = "Broker.RegisterServer"
+var DeregisterServer = "Broker.DeregisterServer"
+var GetSession = "Broker.GetSession"
+var AttachClient = "Broker.AttachClient"
+var DetachClient = "Broker.DetachClient"
+var WaitForSession = "Broker.WaitForSession"
+var ListSessions = "Broker.ListSessions"
+var KillSession = "Broker.KillSession"
+var AdvanceSession = "Broker.AdvanceSession"
+var SetTurnDelay = "Broker.SetTurnDelay"
@@ -0,0 +1,36 @@
+package util
+
+// ActiveTiles returns which tiles have to be recomputed in the next turn: every
+// tile that changed in the last turn and every tile next to one. Tiles are
+// indexed row by row, rows x cols. Neighbours wrap around the board edges even
+// where the topology has dead edges, which only recomputes a few extra tiles;
+// where an edge wraps with a flip, a change next to it activates the whole
+// opposite row or column of tiles.
+func ActiveTiles(changed []bool, rows, cols int, topology Topology) []bool {
+	active := make([]bool, rows*cols)
+	for ty := 0; ty < rows; ty++ {
+		for tx := 0; tx < cols; tx++ {
+			if !changed[ty*cols+tx] {
+				continue
+			}
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					active[((ty+dy+rows)%rows)*cols+(tx+dx+cols)%cols] = true
+				}
+			}
+			if topology == Klein || topology == Projective {
+				if ty == 0 || ty == rows-1 {
+					for x := 0; x < cols; x++ {
+						active[(rows-1-ty)*cols+x] = true
+					}
+				}
+			}
+			if topology == Projective && (tx == 0 || tx == cols-1) {
+				for y := 0; y < rows; y++ {
+					active[y*cols+(cols-1-tx)] = true
+				}
+			}
+		}
+	}
+	return active
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topology describes how the edges of the board are joined.
+type Topology int
+
+const (
+	Torus      Topology = iota // both axes wrap
+	Plane                      // cells beyond every edge are dead
+	Cylinder                   // left and right edges wrap, top and bottom are dead
+	VCylinder                  // top and bottom edges wrap, left and right are dead
+	Klein                      // left and right wrap, top and bottom wrap with a horizontal flip
+	Projective                 // both axes wrap with a flip
+)
+
+var topologyNames = []string{"torus", "plane", "cylinder", "vcylinder", "klein", "projective"}
+
+// ParseTopology parses a topology name such as "torus" or "klein".
+// An empty string gives the default torus.
+func ParseTopology(name string) (Topology, error) {
+	if name == "" {
+		return Torus, nil
+	}
+	for i, topologyName := range topologyNames {
+		if strings.EqualFold(name, topologyName) {
+			return Topology(i), nil
+		}
+	}
+	return Torus, fmt.Errorf("invalid topology %q: expected one of %s", name, strings.Join(topologyNames, ", "))
+}
+
+func (t Topology) String() string {
+	if t < 0 || int(t) >= len(topologyNames) {
+		return "Incorrect Topology"
+	}
+	return topologyNames[t]
+}
+
+// Neighbour maps the coordinates of a neighbour, which may be one cell outside
+// the board, onto the board. ok is false if the neighbour is beyond a dead edge.
+func (t Topology) Neighbour(x, y, width, height int) (nx, ny int, ok bool) {
+	if x < 0 || x >= width {
+		switch t {
+		case Plane, VCylinder:
+			return 0, 0, false
+		case Projective:
+			y = height - 1 - y
+		}
+		x = (x + width) % width
+	}
+	if y < 0 || y >= height {
+		switch t {
+		case Plane, Cylinder:
+			return 0, 0, false
+		case Klein, Projective:
+			x = width - 1 - x
+		}
+		y = (y + height) % height
+	}
+	return x, y, true
+}
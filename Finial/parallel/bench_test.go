@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"testing"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 const benchLength = 1000
@@ -30,3 +33,75 @@ func BenchmarkStudentVersion(b *testing.B) {
 		})
 	}
 }
+
+const workersBenchLength = 100
+
+// BenchmarkWorkers compares the halo exchange and shared memory byte world workers side by side.
+func BenchmarkWorkers(b *testing.B) {
+	for _, threads := range []int{1, 2, 4, 8, 16} {
+		for _, workers := range []string{"halo", "shared"} {
+			os.Stdout = nil // Disable all program output apart from benchmark results
+			p := gol.Params{
+				Turns:       workersBenchLength,
+				Threads:     threads,
+				ImageWidth:  512,
+				ImageHeight: 512,
+				Engine:      "bytes",
+				Workers:     workers,
+			}
+			name := fmt.Sprintf("%s-%dx%dx%d-%d", p.Workers, p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					events := make(chan gol.Event, 1000)
+					go gol.Run(p, events, nil)
+					for range events {
+					}
+				}
+			})
+		}
+	}
+}
+
+const largeBenchLength = 100
+
+// BenchmarkLargeBoard runs a 4096x4096 random soup, creating the image on first use.
+func BenchmarkLargeBoard(b *testing.B) {
+	createRandomImage(4096, 4096)
+	for _, threads := range []int{1, 4, 8, 16} {
+		os.Stdout = nil // Disable all program output apart from benchmark results
+		p := gol.Params{
+			Turns:       largeBenchLength,
+			Threads:     threads,
+			ImageWidth:  4096,
+			ImageHeight: 4096,
+		}
+		name := fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				events := make(chan gol.Event, 1000)
+				go gol.Run(p, events, nil)
+				for range events {
+				}
+			}
+		})
+	}
+}
+
+// createRandomImage writes images/<width>x<height>.pgm with a fixed random soup if it does not exist yet.
+func createRandomImage(width, height int) {
+	path := fmt.Sprintf("images/%dx%d.pgm", width, height)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	random := rand.New(rand.NewSource(1))
+	image := make([]byte, width*height)
+	for i := range image {
+		if random.Intn(4) == 0 {
+			image[i] = 255
+		}
+	}
+	header := fmt.Sprintf("P5\n%d %d\n255\n", width, height)
+	err := ioutil.WriteFile(path, append([]byte(header), image...), 0644)
+	util.Check(err)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCheckpoint runs the 512x512 image to turn 100 on every engine, resuming from a checkpoint of
+// turn 1 and from the last checkpoint saved while running every 10ms.
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol-checkpoint")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	expectedAlive := readAliveCells("check/images/512x512x100.pgm", 512, 512)
+
+	first := filepath.Join(dir, "first.gol")
+	util.Check(gol.WriteCheckpoint(first, gol.Checkpoint{
+		Params: gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 100},
+		Turn:   1,
+		World:  readCellValues("check/images/512x512x1.pgm", 512, 512),
+	}))
+
+	tests := []gol.Params{
+		{},
+		{Engine: "bytes"},
+		{Engine: "bytes", Workers: "shared"},
+		{Engine: "hashlife"},
+	}
+	for _, p := range tests {
+		p.ImageWidth, p.ImageHeight, p.Turns, p.Threads = 512, 512, 100, 8
+		testName := fmt.Sprintf("engine=%s-workers=%s", p.Engine, p.Workers)
+		t.Run(testName+"-first", func(t *testing.T) {
+			p.Resume = first
+			assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+		})
+		t.Run(testName+"-saved", func(t *testing.T) {
+			saved := filepath.Join(dir, testName+".gol")
+			p.Checkpoint, p.CheckpointEvery = saved, 10*time.Millisecond
+			runToFinalTurn(p)
+			cp, err := gol.ReadCheckpoint(saved)
+			if err != nil {
+				t.Fatalf("No checkpoint was saved: %v", err)
+			}
+			if cp.Turn >= p.Turns {
+				t.Errorf("Checkpoint is from turn %d, after the last turn", cp.Turn)
+			}
+			p.Checkpoint, p.Resume = "", saved
+			assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+		})
+	}
+
+	t.Run("broken", func(t *testing.T) {
+		header := "GOL-CHECKPOINT 1\nturns 10\nturn 5\nrule B3/S23\ntopology torus\n"
+		tests := map[string]string{
+			"an unknown version": "GOL-CHECKPOINT 2\nwidth 1\nheight 1\nworld\n\x00",
+			"a huge size":        header + "width 1000000000\nheight 1000000000\nworld\n\x00",
+			"a short world":      header + "width 2\nheight 2\nworld\n\x00\xff\x00",
+			"a long world":       header + "width 2\nheight 2\nworld\n\x00\xff\x00\xff\x00",
+		}
+		for name, text := range tests {
+			path := filepath.Join(dir, "broken.gol")
+			util.Check(ioutil.WriteFile(path, []byte(text), 0644))
+			_, err := gol.ReadCheckpoint(path)
+			if err == nil {
+				t.Errorf("Expected a checkpoint with %s to be rejected", name)
+			}
+		}
+		path := filepath.Join(dir, "good.gol")
+		util.Check(ioutil.WriteFile(path, []byte(header+"width 2\nheight 2\nworld\n\x00\xff\x00\xff"), 0644))
+		if _, err := gol.ReadCheckpoint(path); err != nil {
+			t.Errorf("Expected a 2x2 checkpoint to be read, got %v", err)
+		}
+	})
+}
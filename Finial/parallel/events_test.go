@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestCellEvents replays the cell events of a 512x512 image for 100 turns on every engine, both
+// batched and one per cell, and checks the number of alive cells after each turn.
+func TestCellEvents(t *testing.T) {
+	tests := []gol.Params{
+		{},
+		{Engine: "bytes"},
+		{Engine: "bytes", Workers: "shared"},
+		{Engine: "hashlife"},
+	}
+	alive := readAliveCounts(512, 512)
+	for _, p := range tests {
+		for _, single := range []bool{false, true} {
+			p.ImageWidth, p.ImageHeight, p.Turns, p.Threads = 512, 512, 100, 8
+			p.SingleCellEvents = single
+			testName := fmt.Sprintf("engine=%s-workers=%s-single=%v", p.Engine, p.Workers, single)
+			t.Run(testName, func(t *testing.T) {
+				board := make([][]bool, p.ImageHeight)
+				for y := range board {
+					board[y] = make([]bool, p.ImageWidth)
+				}
+				count := 0
+				flip := func(x, y int) {
+					board[y][x] = !board[y][x]
+					if board[y][x] {
+						count++
+					} else {
+						count--
+					}
+				}
+				events := make(chan gol.Event)
+				go gol.Run(p, events, nil)
+				for event := range events {
+					switch e := event.(type) {
+					case gol.CellFlipped:
+						if !single {
+							t.Errorf("Got CellFlipped when cell events should be batched")
+						}
+						flip(e.Cell.X, e.Cell.Y)
+					case gol.CellsFlipped:
+						if single {
+							t.Errorf("Got CellsFlipped when cell events should be sent one by one")
+						}
+						for _, cell := range e.Cells {
+							flip(cell.X, cell.Y)
+						}
+					case gol.TurnComplete:
+						if count != alive[e.CompletedTurns] {
+							t.Errorf("Incorrect number of alive cells on turn %d. Was %d, should be %d.", e.CompletedTurns, count, alive[e.CompletedTurns])
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// gliders holds the same glider in every pattern format.
+var gliders = map[gol.PatternFormat]string{
+	gol.FormatRLE:     gliderRLE,
+	gol.FormatCells:   "!Name: Glider\n!The smallest spaceship.\n.O.\n..O\nOOO\n",
+	gol.FormatLife105: "#Life 1.05\n#D The smallest spaceship.\n#N\n#P -1 -1\n.*\n#P 1 0\n*\n#P -1 1\n***\n",
+	gol.FormatLife106: "#Life 1.06\n0 -1\n1 0\n-1 1\n0 1\n1 1\n",
+}
+
+// TestPatternFormats reads a glider in every format, finding the format from the header, and
+// writes and reads back the check/images boards in every format.
+func TestPatternFormats(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		expected := [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+		for format, text := range gliders {
+			pattern, detected, err := gol.ReadPattern(strings.NewReader(text), gol.FormatAuto)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if detected != format {
+				t.Errorf("Expected the format to be detected as %s, got %s", format, detected)
+			}
+			if !reflect.DeepEqual(pattern.Cells, expected) {
+				t.Errorf("%s: expected the glider's cells %v, got %v", format, expected, pattern.Cells)
+			}
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, size := range []int{16, 64, 512} {
+			cells := readCellValues(fmt.Sprintf("check/images/%dx%dx100.pgm", size, size), size, size)
+			for format := range gliders {
+				var buffer bytes.Buffer
+				err := gol.WritePattern(&buffer, gol.Pattern{Name: "board", Rule: util.DefaultRule, Cells: cells}, format)
+				if err != nil {
+					t.Fatalf("%s: %v", format, err)
+				}
+				read, detected, err := gol.ReadPattern(&buffer, gol.FormatAuto)
+				if err != nil {
+					t.Fatalf("%s: %v", format, err)
+				}
+				if detected != format {
+					t.Errorf("Expected the format to be detected as %s, got %s", format, detected)
+				}
+				// Life files only keep the alive cells, not the size of the board.
+				if !reflect.DeepEqual(trimCells(read.Cells), trimCells(cells)) {
+					t.Errorf("The %dx%d board changed when written and read as %s", size, size, format)
+				}
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		decaying := gol.Pattern{Rule: "B2/S/C3", Cells: [][]uint8{{0, 255, 127}}}
+		for _, format := range []gol.PatternFormat{gol.FormatCells, gol.FormatLife105, gol.FormatLife106} {
+			if gol.WritePattern(ioutil.Discard, decaying, format) == nil {
+				t.Errorf("Expected decaying cells to be rejected as %s", format)
+			}
+		}
+		bad := map[gol.PatternFormat]string{
+			gol.FormatCells:   ".O.\n..X\n",
+			gol.FormatLife105: "#Life 1.05\n#P 0\n*\n",
+			gol.FormatLife106: "#Life 1.06\n0 1 2\n",
+		}
+		for format, text := range bad {
+			_, _, err := gol.ReadPattern(strings.NewReader(text), format)
+			if err == nil {
+				t.Errorf("Expected %q to be rejected as %s", text, format)
+			}
+		}
+		// Cells far apart would need a huge board.
+		far := []string{
+			"#Life 1.05\n#P 0 0\n*\n#P 1000000000 1000000000\n*\n",
+			"#Life 1.06\n0 0\n1000000000 1000000000\n",
+			"#Life 1.06\n0 0\n2000000000 2000000000\n",
+			"#Life 1.06\n-9223372036854775807 0\n9223372036854775807 0\n",
+		}
+		for _, text := range far {
+			_, _, err := gol.ReadPattern(strings.NewReader(text), gol.FormatAuto)
+			if err == nil {
+				t.Errorf("Expected %q to be rejected", text)
+			}
+		}
+		// A plaintext pattern is as large as its rows.
+		_, _, err := gol.ReadPattern(strings.NewReader(strings.Repeat("O\n", 70000)), gol.FormatCells)
+		if err == nil {
+			t.Errorf("Expected a plaintext pattern of 70000 rows to be rejected")
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "gol-formats")
+		util.Check(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "glider.cells")
+		util.Check(ioutil.WriteFile(path, []byte(gliders[gol.FormatCells]), 0644))
+
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 8, Threads: 4, Pattern: path, PatternAt: &util.Cell{X: 2, Y: 1}}
+		expected := []util.Cell{{X: 5, Y: 3}, {X: 6, Y: 4}, {X: 4, Y: 5}, {X: 5, Y: 5}, {X: 6, Y: 5}}
+		assertEqualBoard(t, runToFinalTurn(p), expected, p)
+
+		// A game started from a plaintext pattern is saved as one.
+		file, err := os.Open("out/16x16x8.cells")
+		if err != nil {
+			t.Fatalf("Expected the final world to be saved as a plaintext pattern: %v", err)
+		}
+		defer file.Close()
+		saved, err := gol.ReadCells(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(saved.Cells) != 16 || len(saved.Cells[0]) != 16 {
+			t.Errorf("Expected the saved pattern to be the whole 16x16 board")
+		}
+		if !reflect.DeepEqual(trimCells(saved.Cells), [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}) {
+			t.Errorf("Expected the saved pattern to be the glider, got %v", trimCells(saved.Cells))
+		}
+	})
+}
+
+// trimCells cuts the rows of cells down to the alive cells.
+func trimCells(cells [][]uint8) [][]uint8 {
+	top, bottom, left, right := len(cells), -1, -1, -1
+	for y, row := range cells {
+		for x, value := range row {
+			if value == 0 {
+				continue
+			}
+			if y < top {
+				top = y
+			}
+			bottom = y
+			if left == -1 || x < left {
+				left = x
+			}
+			if x > right {
+				right = x
+			}
+		}
+	}
+	var trimmed [][]uint8
+	for y := top; y <= bottom; y++ {
+		trimmed = append(trimmed, cells[y][left:right+1])
+	}
+	return trimmed
+}
@@ -0,0 +1,228 @@
+package gol
+
+import (
+	"math/bits"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// bitBoard is a bit-packed world with 64 cells per word. Bit i of word k in a
+// row is the cell at x = 64*k + i. Bits past the end of a row are always 0.
+//
+// For active-region tracking the board is split into tiles of one word by
+// tileRows rows, indexed by (y/tileRows)*stride + k.
+type bitBoard struct {
+	width, height int
+	stride        int // words per row
+	words         []uint64
+}
+
+const tileRows = 32
+
+func newBitBoard(width, height int) *bitBoard {
+	stride := (width + 63) / 64
+	return &bitBoard{
+		width:  width,
+		height: height,
+		stride: stride,
+		words:  make([]uint64, stride*height),
+	}
+}
+
+// canPack reports whether the bit-packed kernel can run a rule on a topology.
+// Generations rules need more than one bit per cell and the projective plane
+// flips rows per cell at the side edges, so both use the byte world instead.
+func canPack(rule util.Rule, topology util.Topology) bool {
+	return rule.States == 2 && topology != util.Projective
+}
+
+// packWorld converts a byte world into a bitBoard. Only alive (255) cells are kept.
+func packWorld(world [][]uint8, width, height int) *bitBoard {
+	b := newBitBoard(width, height)
+	for y := 0; y < height; y++ {
+		row := b.row(y)
+		for x := 0; x < width; x++ {
+			if world[y][x] == 255 {
+				row[x>>6] |= 1 << uint(x&63)
+			}
+		}
+	}
+	return b
+}
+
+// unpack converts the bitBoard back into a byte world.
+func (b *bitBoard) unpack() [][]uint8 {
+	world := createNewPiece(b.height, b.width)
+	for y := 0; y < b.height; y++ {
+		row := b.row(y)
+		for x := 0; x < b.width; x++ {
+			if row[x>>6]&(1<<uint(x&63)) != 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+func (b *bitBoard) row(y int) []uint64 {
+	return b.words[y*b.stride : (y+1)*b.stride]
+}
+
+// tileCount returns the number of tiles down and across the board.
+func (b *bitBoard) tileCount() (rows, cols int) {
+	return (b.height + tileRows - 1) / tileRows, b.stride
+}
+
+// count returns the number of alive cells.
+func (b *bitBoard) count() int {
+	counter := 0
+	for _, word := range b.words {
+		counter += bits.OnesCount64(word)
+	}
+	return counter
+}
+
+// lastMask has a bit set for every valid cell in the last word of a row.
+func (b *bitBoard) lastMask() uint64 {
+	if b.width%64 == 0 {
+		return ^uint64(0)
+	}
+	return 1<<uint(b.width%64) - 1
+}
+
+// reversedRow returns row y mirrored horizontally, used where a Klein bottle wraps.
+func (b *bitBoard) reversedRow(y int) []uint64 {
+	row := b.row(y)
+	reversed := make([]uint64, b.stride)
+	for x := 0; x < b.width; x++ {
+		if row[x>>6]&(1<<uint(x&63)) != 0 {
+			mirrored := b.width - 1 - x
+			reversed[mirrored>>6] |= 1 << uint(mirrored&63)
+		}
+	}
+	return reversed
+}
+
+// neighbourRow returns the row above (dy = -1) or below (dy = 1) row y, or nil if it is beyond a dead edge.
+func (b *bitBoard) neighbourRow(y, dy int, topology util.Topology) []uint64 {
+	ny := y + dy
+	if ny >= 0 && ny < b.height {
+		return b.row(ny)
+	}
+	ny = (ny + b.height) % b.height
+	switch topology {
+	case util.Plane, util.Cylinder:
+		return nil
+	case util.Klein:
+		return b.reversedRow(ny)
+	default:
+		return b.row(ny)
+	}
+}
+
+// shifted returns word k of a row moved so that each bit holds its west and east neighbour.
+func (b *bitBoard) shifted(row []uint64, k int, wrap bool) (west, east uint64) {
+	if row == nil {
+		return 0, 0
+	}
+	last := b.stride - 1
+	word := row[k]
+	west = word << 1
+	if k > 0 {
+		west |= row[k-1] >> 63
+	} else if wrap {
+		west |= row[last] >> uint((b.width-1)&63) & 1
+	}
+	east = word >> 1
+	if k < last {
+		east |= row[k+1] << 63
+	} else if wrap {
+		east |= (row[0] & 1) << uint((b.width-1)&63)
+	}
+	return west, east
+}
+
+// calculateNextPackedState computes rows startY to endY of the next turn into next,
+// recording every cell that changed in events. Only active tiles are
+// computed: the others did not change last turn, so next already holds them.
+// Tiles with a cell that changed are marked in changed.
+func calculateNextPackedState(startY, endY int, current, next *bitBoard, events *cellEvents, rule util.Rule, topology util.Topology, active, changed []bool) {
+	wrapX := topology == util.Torus || topology == util.Cylinder || topology == util.Klein
+	lastMask := current.lastMask()
+
+	// For every neighbour count, whether a dead cell is born or an alive cell survives.
+	var born, survive [9]uint64
+	for n := 0; n <= 8; n++ {
+		if rule.Born[n] {
+			born[n] = ^uint64(0)
+		}
+		if rule.Survive[n] {
+			survive[n] = ^uint64(0)
+		}
+	}
+
+	for y := startY; y < endY; y++ {
+		tiles := (y / tileRows) * current.stride
+		up := current.neighbourRow(y, -1, topology)
+		row := current.row(y)
+		down := current.neighbourRow(y, 1, topology)
+		out := next.row(y)
+
+		for k := 0; k < current.stride; k++ {
+			if !active[tiles+k] {
+				continue
+			}
+			var upword, downword uint64
+			if up != nil {
+				upword = up[k]
+			}
+			if down != nil {
+				downword = down[k]
+			}
+			upWest, upEast := current.shifted(up, k, wrapX)
+			west, east := current.shifted(row, k, wrapX)
+			downWest, downEast := current.shifted(down, k, wrapX)
+
+			// Bit-sliced counter: bit i of s0..s3 is the binary neighbour count of cell i.
+			var s0, s1, s2, s3 uint64
+			for _, v := range [8]uint64{upWest, upword, upEast, west, east, downWest, downword, downEast} {
+				c0 := s0 & v
+				s0 ^= v
+				c1 := s1 & c0
+				s1 ^= c0
+				c2 := s2 & c1
+				s2 ^= c1
+				s3 |= c2
+			}
+
+			alive := row[k]
+			var result uint64
+			for n := 0; n <= 8; n++ {
+				if born[n]|survive[n] == 0 {
+					continue
+				}
+				equal := ^uint64(0)
+				for bit, plane := range [4]uint64{s0, s1, s2, s3} {
+					if n&(1<<uint(bit)) != 0 {
+						equal &= plane
+					} else {
+						equal &^= plane
+					}
+				}
+				result |= equal & ((alive & survive[n]) | (^alive & born[n]))
+			}
+			if k == current.stride-1 {
+				result &= lastMask
+			}
+			out[k] = result
+			if result != alive {
+				changed[tiles+k] = true
+			}
+
+			for flipped := alive ^ result; flipped != 0; flipped &= flipped - 1 {
+				x := k<<6 + bits.TrailingZeros64(flipped)
+				events.flip(util.Cell{X: x, Y: y})
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package gol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// checkpointVersion is written on the first line of every checkpoint and has to
+// be increased whenever the format changes.
+const checkpointVersion = 1
+
+// Checkpoint is a game saved to disk: the world after Turn of Params.Turns turns.
+// Only the size, number of turns, rule and topology of Params are kept.
+//
+// On disk a checkpoint is a line "GOL-CHECKPOINT <version>", one "key value"
+// line for each of width, height, turns, turn, rule and topology, a line
+// "world" and then the cells, one byte each, row by row.
+type Checkpoint struct {
+	Params Params
+	Turn   int
+	World  [][]uint8
+}
+
+// WriteCheckpoint saves a checkpoint to path. It is written to a temporary file
+// first, so a crash while saving leaves the previous checkpoint in place.
+func WriteCheckpoint(path string, cp Checkpoint) error {
+	rule, err := util.ParseRule(cp.Params.Rule)
+	if err != nil {
+		return err
+	}
+	topology, err := util.ParseTopology(cp.Params.Topology)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "GOL-CHECKPOINT %d\n", checkpointVersion)
+	fmt.Fprintf(w, "width %d\n", cp.Params.ImageWidth)
+	fmt.Fprintf(w, "height %d\n", cp.Params.ImageHeight)
+	fmt.Fprintf(w, "turns %d\n", cp.Params.Turns)
+	fmt.Fprintf(w, "turn %d\n", cp.Turn)
+	fmt.Fprintf(w, "rule %s\n", rule)
+	fmt.Fprintf(w, "topology %s\n", topology)
+	fmt.Fprintf(w, "world\n")
+	for _, row := range cp.World {
+		w.Write(row)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ReadCheckpoint loads a checkpoint saved by WriteCheckpoint.
+func ReadCheckpoint(path string) (Checkpoint, error) {
+	var cp Checkpoint
+	file, err := os.Open(path)
+	if err != nil {
+		return cp, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return cp, fmt.Errorf("%s is not a checkpoint", path)
+	}
+	var version int
+	_, err = fmt.Sscanf(line, "GOL-CHECKPOINT %d\n", &version)
+	if err != nil {
+		return cp, fmt.Errorf("%s is not a checkpoint", path)
+	}
+	if version != checkpointVersion {
+		return cp, fmt.Errorf("checkpoint version %d is not supported, expected %d", version, checkpointVersion)
+	}
+
+	// headerSize counts the bytes before the cells, to check the size of the world against the file.
+	headerSize := int64(len(line))
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return cp, errors.New("checkpoint has no world")
+		}
+		headerSize += int64(len(line))
+		fields := strings.Fields(line)
+		if len(fields) == 1 && fields[0] == "world" {
+			break
+		}
+		if len(fields) != 2 {
+			return cp, fmt.Errorf("invalid checkpoint line %q", strings.TrimSpace(line))
+		}
+		switch fields[0] {
+		case "rule":
+			cp.Params.Rule = fields[1]
+		case "topology":
+			cp.Params.Topology = fields[1]
+		case "width", "height", "turns", "turn":
+			value, err := strconv.Atoi(fields[1])
+			if err != nil || value < 0 {
+				return cp, fmt.Errorf("invalid checkpoint %s %q", fields[0], fields[1])
+			}
+			switch fields[0] {
+			case "width":
+				cp.Params.ImageWidth = value
+			case "height":
+				cp.Params.ImageHeight = value
+			case "turns":
+				cp.Params.Turns = value
+			default:
+				cp.Turn = value
+			}
+		default:
+			return cp, fmt.Errorf("unknown checkpoint key %q", fields[0])
+		}
+	}
+
+	_, err = util.ParseRule(cp.Params.Rule)
+	if err != nil {
+		return cp, err
+	}
+	_, err = util.ParseTopology(cp.Params.Topology)
+	if err != nil {
+		return cp, err
+	}
+	if cp.Params.ImageWidth == 0 || cp.Params.ImageHeight == 0 {
+		return cp, errors.New("checkpoint has no size")
+	}
+	if cp.Params.ImageWidth > maxImageSide || cp.Params.ImageHeight > maxImageSide {
+		return cp, fmt.Errorf("checkpoint of %dx%d cells is too large", cp.Params.ImageWidth, cp.Params.ImageHeight)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return cp, err
+	}
+	cells := int64(cp.Params.ImageWidth) * int64(cp.Params.ImageHeight)
+	if info.Size()-headerSize != cells {
+		return cp, fmt.Errorf("checkpoint world has %d cells, expected %dx%d", info.Size()-headerSize, cp.Params.ImageWidth, cp.Params.ImageHeight)
+	}
+	if cp.Turn > cp.Params.Turns {
+		return cp, fmt.Errorf("checkpoint turn %d is past its last turn %d", cp.Turn, cp.Params.Turns)
+	}
+	cp.World = createNewPiece(cp.Params.ImageHeight, cp.Params.ImageWidth)
+	for _, row := range cp.World {
+		_, err = io.ReadFull(r, row)
+		if err != nil {
+			return cp, errors.New("checkpoint world is too short")
+		}
+	}
+	return cp, nil
+}
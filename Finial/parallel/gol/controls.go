@@ -0,0 +1,56 @@
+package gol
+
+import (
+	"fmt"
+	"time"
+)
+
+// The turn rate is set with + and -. Each - doubles the delay between turns,
+// starting from minTurnDelay, and each + halves it until there is none.
+const (
+	minTurnDelay = 10 * time.Millisecond
+	maxTurnDelay = time.Second
+)
+
+// throttle returns the delay between turns after key, which is + or -.
+func throttle(delay time.Duration, key rune) time.Duration {
+	switch key {
+	case '-':
+		delay *= 2
+		if delay < minTurnDelay {
+			delay = minTurnDelay
+		}
+		if delay > maxTurnDelay {
+			delay = maxTurnDelay
+		}
+	case '+':
+		delay /= 2
+		if delay < minTurnDelay {
+			delay = 0
+		}
+	}
+	fmt.Println("Delay between turns:", delay)
+	return delay
+}
+
+// readTurn reads the turn to fast-forward to after f: digits ended by Enter.
+// It returns -1 if no digits were typed. Any other key cancels the fast-forward
+// and is returned so the pause loop can handle it, or 0 if Enter was pressed.
+func readTurn(keyPresses <-chan rune) (int, rune) {
+	fmt.Println("Type the turn to fast-forward to and press Enter.")
+	turn := -1
+	for key := range keyPresses {
+		if key == '\n' || key == '\r' {
+			break
+		}
+		if key < '0' || key > '9' {
+			fmt.Println("Fast-forward cancelled.")
+			return -1, key
+		}
+		if turn == -1 {
+			turn = 0
+		}
+		turn = turn*10 + int(key-'0')
+	}
+	return turn, 0
+}
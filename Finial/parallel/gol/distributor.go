@@ -2,7 +2,6 @@ package gol
 
 import (
 	"fmt"
-	"os"
 	"time"
 	"uk.ac.bris.cs/gameoflife/util"
 )
@@ -14,77 +13,147 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioLoaded   <-chan error
 	keyPresses <-chan rune
 }
 
 // distributor divides the work between workers and interacts with other goroutines.
 func distributor(p Params, c distributorChannels) {
 
+	rule, err := util.ParseRule(p.Rule)
+	if err != nil {
+		stopGame(c, 0, err)
+		return
+	}
+	topology, err := util.ParseTopology(p.Topology)
+	if err != nil {
+		stopGame(c, 0, err)
+		return
+	}
+
 	// TODO: Create a 2D slice to store the world.
 	world := createNewWorld(p)
-	world = loadWorld(p, c)
+	turn := 0
+	if p.Resume != "" {
+		world, turn, err = resumeWorld(p, c)
+	} else {
+		world, err = loadWorld(p, c)
+	}
+	if err != nil {
+		stopGame(c, 0, fmt.Errorf("loading the world: %v", err))
+		return
+	}
+
+	e, err := newEngine(p, c, world, rule, topology)
+	if err != nil {
+		stopGame(c, turn, err)
+		return
+	}
 
 	ticker := time.NewTicker(time.Second * 2) //Event should be sent every 2s.
 	defer ticker.Stop()
-	turn := 0
+	// The next checkpoint is timed from the end of the last, so slow saves never hold up the turns.
+	var checkpoint <-chan time.Time
+	if p.Checkpoint != "" && p.CheckpointEvery > 0 {
+		checkpoint = time.After(p.CheckpointEvery)
+	}
+	delay := time.Duration(0)
 	for turn < p.Turns {
 		select {
 		case <-ticker.C:
-			c.events <- AliveCellsCount{turn, countCell(world)}
+			c.events <- AliveCellsCount{turn, e.aliveCount()}
+		case <-checkpoint:
+			saveCheckpoint(p, e.currentWorld(), turn)
+			checkpoint = time.After(p.CheckpointEvery)
 		case key := <-c.keyPresses:
-			handleKeyPress(p, key, c, world, turn)
+			var quit bool
+			turn, quit = handleKeyPress(p, key, c, e, turn, &delay)
+			if quit {
+				e.stop()
+				c.ioCommand <- ioCheckIdle
+				<-c.ioIdle
+				c.events <- StateChange{turn, Quitting}
+				close(c.events)
+				return
+			}
 
 		default:
-			turn++
-			world = executeTurn(p, c, world, turn)
+			if delay == 0 {
+				turn = e.step(c, turn, p.Turns)
+			} else {
+				// A throttled game goes one turn at a time, even on HashLife.
+				turn = e.step(c, turn, turn+1)
+				time.Sleep(delay)
+			}
 		}
 
 	}
 
+	world = e.currentWorld()
+	e.stop()
 	finalizeGame(p, c, world)
 
 }
 
-// handle different keys
-func handleKeyPress(p Params, key rune, c distributorChannels, world [][]uint8, turn int) {
+// handleKeyPress handles a key and returns the turn after it, which only changes
+// when stepping while paused, and whether the game should quit.
+func handleKeyPress(p Params, key rune, c distributorChannels, e engine, turn int, delay *time.Duration) (int, bool) {
 	switch key {
 	case 's':
 		//handle save command
-		c.ioCommand <- ioOutput
 		fileName := fmt.Sprintf("output_%d", turn)
-		c.ioFilename <- fileName
-		for y := 0; y < p.ImageHeight; y++ {
-			for x := 0; x < p.ImageWidth; x++ {
-				c.ioOutput <- world[y][x]
-			}
-		}
+		writeWorld(p, c, fileName, e.currentWorld())
 		c.events <- ImageOutputComplete{turn, fileName}
 		fmt.Println("Saved current state to PGM image.")
 	case 'q':
 		// handle quit command
-		c.events <- StateChange{turn, Quitting}
-		c.ioCommand <- ioOutput
-		c.ioFilename <- fmt.Sprintf("output_%d", turn)
-		for _, row := range world {
-			for _, value := range row {
-				c.ioOutput <- value
-			}
-		}
+		writeWorld(p, c, fmt.Sprintf("output_%d", turn), e.currentWorld())
 		fmt.Println("Saved current state to PGM image and quit.")
-		os.Exit(0)
+		return turn, true
 
 	case 'p':
-		//handle pause command
+		//handle pause command; n and f step through turns while paused
 		c.events <- StateChange{turn, Paused}
+		// next is a key that ended typing a turn after f, to be handled next.
+		next := rune(0)
 		for {
-			tem := <-c.keyPresses
+			tem := next
+			if tem == 0 {
+				tem = <-c.keyPresses
+			}
+			next = 0
 			if tem == 'p' {
 				c.events <- StateChange{turn, Executing}
 				break
 			}
+			switch tem {
+			case 'n':
+				if turn < p.Turns {
+					turn = e.step(c, turn, turn+1)
+				}
+			case 'f':
+				var target int
+				target, next = readTurn(c.keyPresses)
+				if target > p.Turns {
+					target = p.Turns
+				}
+				for turn < target {
+					turn = e.step(c, turn, target)
+				}
+			case '+', '-':
+				*delay = throttle(*delay, tem)
+			}
+			// The game is over once the final turn is reached, paused or not.
+			if turn == p.Turns {
+				c.events <- StateChange{turn, Executing}
+				break
+			}
 		}
 
+	case '+', '-':
+		*delay = throttle(*delay, key)
 	}
+	return turn, false
 }
 
 // count the number of cells
@@ -100,34 +169,6 @@ func countCell(world [][]uint8) int {
 	return counter
 }
 
-// TODO: Execute all turns of the Game of Life.
-func executeTurn(p Params, c distributorChannels, world [][]uint8, turn int) [][]uint8 {
-	res := createNewWorld(p)
-
-	if p.Threads == 1 {
-		res = calculateNextState(p, 0, p.ImageHeight, 0, p.ImageWidth, world, c, p.Turns)
-		c.events <- TurnComplete{turn}
-	} else {
-		outChan := make([]chan [][]uint8, p.Threads)
-		for i := 0; i < p.Threads; i++ {
-			outChan[i] = make(chan [][]uint8)
-		}
-		for i := 0; i < p.Threads; i++ {
-			go worker(p, i*p.ImageHeight/p.Threads, (i+1)*p.ImageHeight/p.Threads, 0, p.ImageWidth, world, outChan[i], c, turn)
-		}
-		res = nil
-		for i := 0; i < p.Threads; i++ {
-			part := <-outChan[i]
-			res = append(res, part...)
-		}
-
-	}
-	c.events <- TurnComplete{turn}
-	return res
-}
-func worker(p Params, startY, endY, startX, endX int, world [][]uint8, outChan chan<- [][]uint8, c distributorChannels, turn int) {
-	outChan <- calculateNextState(p, startY, endY, startX, endX, world, c, turn)
-}
 func createNewPiece(height, width int) [][]uint8 {
 	newWorld := make([][]uint8, height)
 	for v := range newWorld {
@@ -139,14 +180,8 @@ func createNewPiece(height, width int) [][]uint8 {
 func finalizeGame(p Params, c distributorChannels, world [][]uint8) {
 	// TODO: Report the final state using FinalTurnCompleteEvent.
 	//output
-	fileName := fmt.Sprintf("%dx%dx%d", p.ImageHeight, p.ImageWidth, p.Turns)
-	c.ioCommand <- ioOutput
-	c.ioFilename <- fileName
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			c.ioOutput <- world[y][x]
-		}
-	}
+	fileName := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns)
+	writeWorld(p, c, fileName, world)
 
 	c.events <- ImageOutputComplete{p.Turns, fileName}
 
@@ -162,22 +197,98 @@ func finalizeGame(p Params, c distributorChannels, world [][]uint8) {
 
 }
 
+// writeWorld saves the world as <fileName>.pgm in the output directory. A game started from a
+// pattern is saved as a pattern in the same format too.
+func writeWorld(p Params, c distributorChannels, fileName string, world [][]uint8) {
+	commands := []ioCommand{ioOutput}
+	if p.Pattern != "" {
+		commands = append(commands, patternOutputs[p.PatternFormat])
+	}
+	for _, command := range commands {
+		c.ioCommand <- command
+		c.ioFilename <- fileName
+		for y := 0; y < p.ImageHeight; y++ {
+			for x := 0; x < p.ImageWidth; x++ {
+				c.ioOutput <- world[y][x]
+			}
+		}
+	}
+}
+
 // load world data
-func loadWorld(p Params, c distributorChannels) [][]uint8 {
-	c.ioCommand <- ioInput
+func loadWorld(p Params, c distributorChannels) ([][]uint8, error) {
 	res := createNewPiece(p.ImageHeight, p.ImageWidth)
-	filename := fmt.Sprintf("%dx%d", p.ImageHeight, p.ImageWidth)
-	c.ioFilename <- filename
+	if p.Pattern != "" {
+		c.ioCommand <- patternInputs[p.PatternFormat]
+		c.ioFilename <- p.Pattern
+	} else {
+		c.ioCommand <- ioInput
+		c.ioFilename <- inputPath(p)
+	}
+	err := <-c.ioLoaded
+	if err != nil {
+		return nil, err
+	}
+	events := newCellEvents(p, c, 0)
 	for y := 0; y < p.ImageHeight; y++ {
 		for x := 0; x < p.ImageWidth; x++ {
 			input := <-c.ioInput
 			if input != 0 {
 				res[y][x] = input
-				c.events <- CellFlipped{0, util.Cell{X: y, Y: x}}
+				events.change(util.Cell{X: x, Y: y}, 0, input)
+			}
+		}
+	}
+	events.send()
+	return res, nil
+}
+
+// stopGame ends a game that can't go on after turn because of err.
+func stopGame(c distributorChannels, turn int, err error) {
+	fmt.Println("Error:", err)
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+	c.events <- StateChange{turn, Quitting}
+	close(c.events)
+}
+
+// resumeWorld loads the world and turn saved in the checkpoint p.Resume, which
+// has to be from a game of the same size, rule and topology as p.
+func resumeWorld(p Params, c distributorChannels) ([][]uint8, int, error) {
+	cp, err := ReadCheckpoint(p.Resume)
+	if err != nil {
+		return nil, 0, err
+	}
+	rule, _ := util.ParseRule(p.Rule)
+	topology, _ := util.ParseTopology(p.Topology)
+	savedRule, _ := util.ParseRule(cp.Params.Rule)
+	savedTopology, _ := util.ParseTopology(cp.Params.Topology)
+	if cp.Params.ImageWidth != p.ImageWidth || cp.Params.ImageHeight != p.ImageHeight || savedRule.String() != rule.String() || savedTopology != topology {
+		return nil, 0, fmt.Errorf("checkpoint %s is from a %dx%d %s game on a %s, not %dx%d %s on a %s", p.Resume,
+			cp.Params.ImageWidth, cp.Params.ImageHeight, savedRule, savedTopology, p.ImageWidth, p.ImageHeight, rule, topology)
+	}
+	if cp.Turn > p.Turns {
+		return nil, 0, fmt.Errorf("checkpoint %s is already past turn %d", p.Resume, p.Turns)
+	}
+	events := newCellEvents(p, c, cp.Turn)
+	for y, row := range cp.World {
+		for x, value := range row {
+			if value != 0 {
+				events.change(util.Cell{X: x, Y: y}, 0, value)
 			}
 		}
 	}
-	return res
+	events.send()
+	return cp.World, cp.Turn, nil
+}
+
+// saveCheckpoint saves the world after turn to p.Checkpoint. A checkpoint that
+// cannot be saved does not stop the game.
+func saveCheckpoint(p Params, world [][]uint8, turn int) {
+	err := WriteCheckpoint(p.Checkpoint, Checkpoint{Params: p, Turn: turn, World: world})
+	if err != nil {
+		fmt.Println("Error saving checkpoint:", err)
+	}
 }
 
 // create a new world
@@ -189,52 +300,60 @@ func createNewWorld(p Params) [][]uint8 {
 	return newWorld
 }
 
-func nextCellState(x, y, aliveNeighbors int, currentState uint8, c distributorChannels, turn int) uint8 {
-	// Rules for cell state in the Game of Life
-	switch {
-	case currentState == 255 && aliveNeighbors < 2:
-		return 0 // Underpopulation
-	case currentState == 255 && (aliveNeighbors == 2 || aliveNeighbors == 3):
-		return 255 // Lives on
-	case currentState == 255 && aliveNeighbors > 3:
-		return 0 // Overpopulation
-	case currentState == 0 && aliveNeighbors == 3:
-		return 255 // Reproduction
-	default:
-		return currentState
-	}
-}
-
-func calculateNextState(p Params, startY, endY, startX, endX int, world [][]uint8, c distributorChannels, turn int) [][]uint8 {
-	newWorld := createNewPiece(endY-startY, p.ImageWidth)
-
-	for y := startY; y < endY; y++ {
-		for x := startX; x < endX; x++ {
-			aliveNeighbors := countAliveNeighbors(x, y, p, world)
-			newState := nextCellState(x, y, aliveNeighbors, world[y][x], c, turn)
-			newWorld[y-startY][x] = newState
-			if newState != world[y][x] {
-				c.events <- CellFlipped{turn, util.Cell{X: y, Y: x}}
-			}
-		}
+// sendCellChange sends CellFlipped when a cell becomes alive or stops being alive,
+// and CellStateChanged when it enters or leaves a decay state.
+func sendCellChange(c distributorChannels, turn int, cell util.Cell, oldState, newState uint8) {
+	if oldState == 255 || newState == 255 {
+		c.events <- CellFlipped{turn, cell}
+	}
+	if util.IsDecaying(oldState) || util.IsDecaying(newState) {
+		c.events <- CellStateChanged{turn, cell, newState}
 	}
-	return newWorld
 }
 
-func countAliveNeighbors(x, y int, p Params, world [][]uint8) int {
-	alive := 0
-	for i := -1; i <= 1; i++ {
-		for j := -1; j <= 1; j++ {
-			if !(i == 0 && j == 0) {
-				neighborX := (x + j + p.ImageWidth) & (p.ImageWidth - 1)
-				neighborY := (y + i + p.ImageHeight) & (p.ImageHeight - 1)
-				if world[neighborY][neighborX] != 0 {
-					alive++
-				}
-			}
-		}
+// cellEvents collects the cell changes of one worker in one turn so that its flips
+// are sent as a single CellsFlipped. With Params.SingleCellEvents every change is
+// sent straight away instead.
+type cellEvents struct {
+	c       distributorChannels
+	turn    int
+	single  bool
+	flipped []util.Cell
+	decayed []CellStateChanged
+}
+
+func newCellEvents(p Params, c distributorChannels, turn int) *cellEvents {
+	return &cellEvents{c: c, turn: turn, single: p.SingleCellEvents}
+}
+
+// change records a cell changing from oldState to newState.
+func (e *cellEvents) change(cell util.Cell, oldState, newState uint8) {
+	if e.single {
+		sendCellChange(e.c, e.turn, cell, oldState, newState)
+		return
+	}
+	if oldState == 255 || newState == 255 {
+		e.flipped = append(e.flipped, cell)
+	}
+	if util.IsDecaying(oldState) || util.IsDecaying(newState) {
+		e.decayed = append(e.decayed, CellStateChanged{e.turn, cell, newState})
+	}
+}
+
+// flip records a two-state cell changing between dead and alive.
+func (e *cellEvents) flip(cell util.Cell) {
+	e.change(cell, 0, 255)
+}
+
+// send sends the collected flips and then the decay changes, which have to follow the flips of the same cells.
+func (e *cellEvents) send() {
+	if len(e.flipped) > 0 {
+		e.c.events <- CellsFlipped{e.turn, e.flipped}
+	}
+	for _, event := range e.decayed {
+		e.c.events <- event
 	}
-	return alive
+	e.flipped, e.decayed = nil, nil
 }
 
 func calculateAliveCells(world [][]uint8) []util.Cell {
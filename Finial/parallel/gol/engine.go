@@ -0,0 +1,138 @@
+package gol
+
+import (
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// engine holds the world and advances it, sending cell and TurnComplete events.
+type engine interface {
+	// step advances the world by at least one turn without passing turns and returns the new turn.
+	step(c distributorChannels, turn, turns int) int
+	aliveCount() int
+	currentWorld() [][]uint8
+	// stop ends any goroutines the engine started.
+	stop()
+}
+
+// newEngine picks the engine for p. By default two-state rules run on a bit-packed
+// board and everything else on the byte world; p.Engine "bytes" always uses the
+// byte world and "hashlife" selects HashLife. The byte world runs on a halo exchange
+// worker pool unless p.Workers is "shared".
+func newEngine(p Params, c distributorChannels, world [][]uint8, rule util.Rule, topology util.Topology) (engine, error) {
+	if p.Workers != "" && p.Workers != "halo" && p.Workers != "shared" {
+		return nil, fmt.Errorf("unknown worker mode %q", p.Workers)
+	}
+	switch p.Engine {
+	case "", "bytes":
+		if p.Engine == "" && canPack(rule, topology) {
+			return newPackedEngine(p, c, world, rule, topology), nil
+		}
+		if p.Workers == "shared" {
+			return newSharedPool(p, c, world, rule, topology), nil
+		}
+		return newWorkerPool(p, c, world, rule, topology), nil
+	case "hashlife":
+		return newHashLife(p, world, rule, topology)
+	default:
+		return nil, fmt.Errorf("unknown engine %q", p.Engine)
+	}
+}
+
+// packedEngine runs the world as a bit-packed board, which is only unpacked when the world is needed.
+// Only tiles that changed last turn or border one are recomputed. Like the shared pool, long-lived
+// workers each write a strip of rows into next, and the boards are swapped once they are all done.
+type packedEngine struct {
+	p             Params
+	current, next *bitBoard
+	active        []bool
+	changed       []bool // the tiles that changed this turn
+	rule          util.Rule
+	topology      util.Topology
+	starts        []chan int  // each worker's next turn; closed to stop it
+	done          chan []bool // the tiles a worker changed
+}
+
+// newPackedEngine packs the world and starts a worker for each of p.Threads strips.
+func newPackedEngine(p Params, c distributorChannels, world [][]uint8, rule util.Rule, topology util.Topology) *packedEngine {
+	threads := p.Threads
+	if threads > p.ImageHeight {
+		threads = p.ImageHeight
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	current := packWorld(world, p.ImageWidth, p.ImageHeight)
+	rows, cols := current.tileCount()
+	active := make([]bool, rows*cols)
+	for tile := range active {
+		active[tile] = true
+	}
+	e := &packedEngine{
+		p:        p,
+		current:  current,
+		next:     newBitBoard(p.ImageWidth, p.ImageHeight),
+		active:   active,
+		changed:  make([]bool, rows*cols),
+		rule:     rule,
+		topology: topology,
+		done:     make(chan []bool),
+	}
+	for i := 0; i < threads; i++ {
+		start := make(chan int)
+		e.starts = append(e.starts, start)
+		go e.worker(c, i*p.ImageHeight/threads, (i+1)*p.ImageHeight/threads, start)
+	}
+	return e
+}
+
+// worker computes rows startY to endY every time it is given a turn. Strips may share
+// a row of tiles, so every worker marks its own changes, which step merges.
+func (e *packedEngine) worker(c distributorChannels, startY, endY int, start <-chan int) {
+	changed := make([]bool, len(e.changed))
+	for turn := range start {
+		for tile := range changed {
+			changed[tile] = false
+		}
+		events := newCellEvents(e.p, c, turn)
+		calculateNextPackedState(startY, endY, e.current, e.next, events, e.rule, e.topology, e.active, changed)
+		events.send()
+		e.done <- changed
+	}
+}
+
+func (e *packedEngine) step(c distributorChannels, turn, turns int) int {
+	turn++
+	for _, start := range e.starts {
+		start <- turn
+	}
+	for tile := range e.changed {
+		e.changed[tile] = false
+	}
+	// Barrier: a worker's changes are only read until it is given the next turn.
+	for range e.starts {
+		for tile, tileChanged := range <-e.done {
+			e.changed[tile] = e.changed[tile] || tileChanged
+		}
+	}
+	e.current, e.next = e.next, e.current
+	rows, cols := e.current.tileCount()
+	e.active = util.ActiveTiles(e.changed, rows, cols, e.topology)
+	c.events <- TurnComplete{turn}
+	return turn
+}
+
+func (e *packedEngine) aliveCount() int {
+	return e.current.count()
+}
+
+func (e *packedEngine) currentWorld() [][]uint8 {
+	return e.current.unpack()
+}
+
+func (e *packedEngine) stop() {
+	for _, start := range e.starts {
+		close(start)
+	}
+}
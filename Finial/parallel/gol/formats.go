@@ -0,0 +1,402 @@
+package gol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// PatternFormat is one of the pattern file formats that can be read and written.
+type PatternFormat uint8
+
+// FormatAuto is only used for reading: the format is picked from the file's header.
+const (
+	FormatAuto PatternFormat = iota
+	FormatRLE
+	FormatCells
+	FormatLife105
+	FormatLife106
+)
+
+func (f PatternFormat) String() string {
+	switch f {
+	case FormatRLE:
+		return "RLE"
+	case FormatCells:
+		return "plaintext"
+	case FormatLife105:
+		return "Life 1.05"
+	case FormatLife106:
+		return "Life 1.06"
+	}
+	return "auto"
+}
+
+// Extension returns the file extension used for patterns of this format.
+func (f PatternFormat) Extension() string {
+	switch f {
+	case FormatCells:
+		return "cells"
+	case FormatLife105, FormatLife106:
+		return "lif"
+	}
+	return "rle"
+}
+
+// DetectFormat picks the format of a pattern from its first line that is not empty:
+// "#Life 1.05" and "#Life 1.06" start Life files, ! comments or rows of . and O
+// start plaintext files and anything else is taken to be RLE.
+func DetectFormat(data []byte) PatternFormat {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#Life 1.05"):
+			return FormatLife105
+		case strings.HasPrefix(line, "#Life 1.06"):
+			return FormatLife106
+		case strings.HasPrefix(line, "!") || strings.Trim(line, ".O*") == "":
+			return FormatCells
+		}
+		break
+	}
+	return FormatRLE
+}
+
+// ReadPattern reads a pattern in the given format, or in the format picked by
+// DetectFormat for FormatAuto. It returns the format that was read.
+func ReadPattern(r io.Reader, format PatternFormat) (Pattern, PatternFormat, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Pattern{}, format, err
+	}
+	if format == FormatAuto {
+		format = DetectFormat(data)
+	}
+	var pat Pattern
+	switch format {
+	case FormatCells:
+		pat, err = ReadCells(bytes.NewReader(data))
+	case FormatLife105:
+		pat, err = ReadLife105(bytes.NewReader(data))
+	case FormatLife106:
+		pat, err = ReadLife106(bytes.NewReader(data))
+	default:
+		pat, err = ReadRLE(bytes.NewReader(data))
+	}
+	return pat, format, err
+}
+
+// WritePattern writes a pattern in the given format, RLE for FormatAuto.
+func WritePattern(w io.Writer, pat Pattern, format PatternFormat) error {
+	switch format {
+	case FormatCells:
+		return WriteCells(w, pat)
+	case FormatLife105:
+		return WriteLife105(w, pat)
+	case FormatLife106:
+		return WriteLife106(w, pat)
+	}
+	return WriteRLE(w, pat)
+}
+
+// ReadCells reads a pattern in plaintext form: ! lines for the name and comments,
+// then a line of . (dead) and O (alive) for each row. Plaintext files have no rule.
+func ReadCells(r io.Reader) (Pattern, error) {
+	var pat Pattern
+	var rows []string
+	width := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			text := strings.TrimSpace(line[1:])
+			if strings.HasPrefix(text, "Name:") {
+				pat.Name = strings.TrimSpace(strings.TrimPrefix(text, "Name:"))
+			} else {
+				pat.Comments = append(pat.Comments, text)
+			}
+			continue
+		}
+		if len(line) > width {
+			width = len(line)
+		}
+		rows = append(rows, line)
+		err := checkPatternSize(width, len(rows))
+		if err != nil {
+			return pat, err
+		}
+	}
+	if scanner.Err() != nil {
+		return pat, scanner.Err()
+	}
+
+	pat.Cells = createNewPiece(len(rows), width)
+	for y, row := range rows {
+		for x, char := range row {
+			switch char {
+			case '.':
+			case 'O', '*':
+				pat.Cells[y][x] = 255
+			default:
+				return pat, fmt.Errorf("invalid character %q in pattern", char)
+			}
+		}
+	}
+	return pat, nil
+}
+
+// WriteCells writes a pattern in plaintext form. Every row is written in full so
+// the pattern keeps its size.
+func WriteCells(w io.Writer, pat Pattern) error {
+	bw := bufio.NewWriter(w)
+	if pat.Name != "" {
+		fmt.Fprintf(bw, "!Name: %s\n", pat.Name)
+	}
+	for _, comment := range pat.Comments {
+		fmt.Fprintf(bw, "!%s\n", comment)
+	}
+	line := make([]byte, pat.Width())
+	for _, row := range pat.Cells {
+		for x, value := range row {
+			tag, err := twoStateTag(value, FormatCells)
+			if err != nil {
+				return err
+			}
+			line[x] = tag
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// ReadLife105 reads a pattern in Life 1.05 form: a "#Life 1.05" line, #D comments,
+// #N for Conway's rule or #R with an S/B rule, then #P x y blocks of rows of .
+// (dead) and * (alive) with their top left cell at x, y. The pattern is cut to
+// the alive cells, as the blocks can be anywhere.
+func ReadLife105(r io.Reader) (Pattern, error) {
+	var pat Pattern
+	var alive []util.Cell
+	x, y := 0, 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#Life"):
+		case strings.HasPrefix(line, "#D"):
+			pat.Comments = append(pat.Comments, strings.TrimSpace(line[2:]))
+		case strings.HasPrefix(line, "#N"):
+			pat.Rule = util.DefaultRule
+		case strings.HasPrefix(line, "#R"):
+			pat.Rule = strings.TrimSpace(line[2:])
+		case strings.HasPrefix(line, "#P"):
+			var err error
+			x, y, err = readCoordinates(strings.TrimSpace(line[2:]))
+			if err != nil {
+				return pat, err
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			for i, char := range line {
+				switch char {
+				case '.':
+				case '*':
+					alive = append(alive, util.Cell{X: x + i, Y: y})
+				default:
+					return pat, fmt.Errorf("invalid character %q in pattern", char)
+				}
+			}
+			y++
+		}
+	}
+	if scanner.Err() != nil {
+		return pat, scanner.Err()
+	}
+	rule, err := parsePatternRule(pat.Rule)
+	if err != nil {
+		return pat, err
+	}
+	if rule.States != 2 {
+		return pat, fmt.Errorf("rule %s has more than 2 states", rule)
+	}
+	pat.Cells, err = cellsAround(alive)
+	return pat, err
+}
+
+// WriteLife105 writes a pattern in Life 1.05 form as a single #P block at 0, 0.
+// Life 1.05 has no name, so the name is written as the first comment.
+func WriteLife105(w io.Writer, pat Pattern) error {
+	rule, err := parsePatternRule(pat.Rule)
+	if err != nil {
+		return err
+	}
+	if rule.States != 2 {
+		return fmt.Errorf("rule %s can't be written as %s", rule, FormatLife105)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#Life 1.05")
+	if pat.Name != "" {
+		fmt.Fprintf(bw, "#D %s\n", pat.Name)
+	}
+	for _, comment := range pat.Comments {
+		fmt.Fprintf(bw, "#D %s\n", comment)
+	}
+	if rule.String() == util.DefaultRule {
+		fmt.Fprintln(bw, "#N")
+	} else {
+		var s, b strings.Builder
+		for n := 0; n <= 8; n++ {
+			if rule.Survive[n] {
+				s.WriteString(strconv.Itoa(n))
+			}
+			if rule.Born[n] {
+				b.WriteString(strconv.Itoa(n))
+			}
+		}
+		fmt.Fprintf(bw, "#R %s/%s\n", s.String(), b.String())
+	}
+	fmt.Fprintln(bw, "#P 0 0")
+	for _, row := range pat.Cells {
+		end := len(row)
+		for end > 0 && row[end-1] == 0 {
+			end--
+		}
+		// A row of no cells at all could be taken for the end of the block.
+		if end == 0 && len(row) > 0 {
+			end = 1
+		}
+		line := make([]byte, end)
+		for x := range line {
+			tag, err := twoStateTag(row[x], FormatLife105)
+			if err != nil {
+				return err
+			}
+			if tag == 'O' {
+				tag = '*'
+			}
+			line[x] = tag
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// ReadLife106 reads a pattern in Life 1.06 form: a "#Life 1.06" line and then an
+// "x y" line for each alive cell. The pattern is cut to the alive cells.
+func ReadLife106(r io.Reader) (Pattern, error) {
+	var pat Pattern
+	var alive []util.Cell
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		x, y, err := readCoordinates(line)
+		if err != nil {
+			return pat, err
+		}
+		alive = append(alive, util.Cell{X: x, Y: y})
+	}
+	if scanner.Err() != nil {
+		return pat, scanner.Err()
+	}
+	cells, err := cellsAround(alive)
+	pat.Cells = cells
+	return pat, err
+}
+
+// WriteLife106 writes the alive cells of a pattern in Life 1.06 form. Life 1.06
+// has no name, comments or rule.
+func WriteLife106(w io.Writer, pat Pattern) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#Life 1.06")
+	for y, row := range pat.Cells {
+		for x, value := range row {
+			tag, err := twoStateTag(value, FormatLife106)
+			if err != nil {
+				return err
+			}
+			if tag == 'O' {
+				fmt.Fprintf(bw, "%d %d\n", x, y)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// maxCoordinate is the furthest a cell of a Life file can be from 0, 0.
+const maxCoordinate = 1 << 30
+
+// readCoordinates reads a line "x y" of two integers.
+func readCoordinates(line string) (int, int, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid coordinates %q", line)
+	}
+	x, errX := strconv.Atoi(fields[0])
+	y, errY := strconv.Atoi(fields[1])
+	if errX != nil || errY != nil {
+		return 0, 0, fmt.Errorf("invalid coordinates %q", line)
+	}
+	// Keeping coordinates well inside int means the size of the pattern can't overflow.
+	if x < -maxCoordinate || x > maxCoordinate || y < -maxCoordinate || y > maxCoordinate {
+		return 0, 0, fmt.Errorf("coordinates %q are too far out", line)
+	}
+	return x, y, nil
+}
+
+// cellsAround returns the smallest rows of cells that hold all the alive cells,
+// or an error if they cover too many cells to load.
+func cellsAround(alive []util.Cell) ([][]uint8, error) {
+	if len(alive) == 0 {
+		return [][]uint8{}, nil
+	}
+	minX, minY, maxX, maxY := alive[0].X, alive[0].Y, alive[0].X, alive[0].Y
+	for _, cell := range alive {
+		if cell.X < minX {
+			minX = cell.X
+		}
+		if cell.X > maxX {
+			maxX = cell.X
+		}
+		if cell.Y < minY {
+			minY = cell.Y
+		}
+		if cell.Y > maxY {
+			maxY = cell.Y
+		}
+	}
+	err := checkPatternSize(maxX-minX+1, maxY-minY+1)
+	if err != nil {
+		return nil, err
+	}
+	cells := createNewPiece(maxY-minY+1, maxX-minX+1)
+	for _, cell := range alive {
+		cells[cell.Y-minY][cell.X-minX] = 255
+	}
+	return cells, nil
+}
+
+// twoStateTag returns O for alive and . for dead. Other values are decay states,
+// which formats without a rule can't hold.
+func twoStateTag(value uint8, format PatternFormat) (byte, error) {
+	switch value {
+	case 0:
+		return '.', nil
+	case 255:
+		return 'O', nil
+	}
+	return 0, errors.New("decaying cells can't be written as " + format.String())
+}
@@ -1,16 +1,50 @@
 package gol
 
+import (
+	"fmt"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Rule        string // rule in B/S or B/S/C notation, e.g. "B36/S23" or "B2/S/C3"; empty means B3/S23
+	Topology    string // torus, plane, cylinder, vcylinder, klein or projective; empty means torus
+	Engine      string // "bytes" forces one byte per cell, "hashlife" jumps many turns at once; empty picks the fastest exact engine
+	Workers     string // how byte world workers see the board: "halo" or "shared"; empty means halo
+
+	InputPath string // image to load; empty means images/<width>x<height>.pgm, otherwise a zero width or height is read from its header
+	OutputDir string // directory images and patterns are saved to; empty means out
+
+	SingleCellEvents bool // send one CellFlipped per changed cell instead of one CellsFlipped per worker and turn
+
+	Checkpoint      string        // file the game is saved to every CheckpointEvery; empty means no checkpoints
+	CheckpointEvery time.Duration // time between checkpoints
+	Resume          string        // checkpoint to carry on from instead of loading the image
+
+	Pattern       string        // pattern file to load instead of the image; Rule still picks the rule
+	PatternFormat PatternFormat // format of Pattern; FormatAuto picks it from the file's header
+	PatternAt     *util.Cell    // where the pattern's top left cell goes; nil puts the pattern in the middle
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 
+	if p.InputPath != "" && (p.ImageWidth == 0 || p.ImageHeight == 0) {
+		width, height, err := ImageSize(p.InputPath)
+		if err != nil {
+			fmt.Println(err)
+			close(events)
+			return
+		}
+		p.ImageWidth, p.ImageHeight = width, height
+	}
+
 	//	TODO: Put the missing channels in here.
 
 	ioCommand := make(chan ioCommand)
@@ -18,12 +52,14 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	ioFilename := make(chan string)
 	ioOutput := make(chan uint8)
 	ioInput := make(chan uint8)
+	ioLoaded := make(chan error)
 	ioChannels := ioChannels{
 		command:  ioCommand,
 		idle:     ioIdle,
 		filename: ioFilename,
 		output:   ioOutput,
 		input:    ioInput,
+		loaded:   ioLoaded,
 	}
 	go startIo(p, ioChannels)
 
@@ -35,6 +71,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioFilename: ioFilename,
 		ioOutput:   ioOutput,
 		ioInput:    ioInput,
+		ioLoaded:   ioLoaded,
 	}
 	distributor(p, distributorChannels)
 }
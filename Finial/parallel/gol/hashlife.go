@@ -0,0 +1,332 @@
+package gol
+
+import (
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// node is a canonical quadtree node of a HashLife universe covering 2^level x 2^level cells.
+// Level 0 nodes are single cells. Equal subtrees are always the same *node, so
+// results can be memoised on the node itself.
+type node struct {
+	nw, ne, sw, se *node
+	level          int
+	population     int
+	results        []*node // results[j] is the centre of the node advanced 2^j turns
+}
+
+type quad struct {
+	nw, ne, sw, se *node
+}
+
+// hashLife advances a toroidal world with the HashLife algorithm, jumping up to
+// 2^k turns at a time instead of computing every generation.
+type hashLife struct {
+	p             Params
+	rule          util.Rule
+	width, height int
+	nodes         map[quad]*node
+	dead, alive   *node
+	empty         []*node // empty[level] is the empty node of that level
+
+	// A square power-of-two board is held as a single node so that whole jumps
+	// stay inside the quadtree. Any other size is held as a flat world that is
+	// tiled into a quadtree for every jump.
+	square bool
+	torus  *node
+	world  [][]uint8
+}
+
+// maxHashLifeNodes is the number of nodes kept before the memoised results are thrown away.
+const maxHashLifeNodes = 1 << 22
+
+func newHashLife(p Params, world [][]uint8, rule util.Rule, topology util.Topology) (*hashLife, error) {
+	if rule.States != 2 || topology != util.Torus {
+		return nil, fmt.Errorf("the hashlife engine only supports two-state rules on a torus")
+	}
+	// Empty nodes are memoised as staying empty, which is wrong if empty space comes alive.
+	if rule.Born[0] {
+		return nil, fmt.Errorf("the hashlife engine does not support rule %s, where dead cells with no neighbours are born", rule)
+	}
+	h := &hashLife{
+		p:      p,
+		rule:   rule,
+		width:  p.ImageWidth,
+		height: p.ImageHeight,
+		square: p.ImageWidth == p.ImageHeight && p.ImageWidth >= 4 && p.ImageWidth&(p.ImageWidth-1) == 0,
+	}
+	h.reset()
+	if h.square {
+		h.torus = h.build(h.levelFor(p.ImageWidth), 0, 0, world)
+	} else {
+		h.world = world
+	}
+	return h, nil
+}
+
+// reset forgets every node and memoised result.
+func (h *hashLife) reset() {
+	h.nodes = make(map[quad]*node)
+	h.dead = &node{}
+	h.alive = &node{population: 1}
+	h.empty = []*node{h.dead}
+}
+
+func (h *hashLife) levelFor(size int) int {
+	level := 0
+	for 1<<uint(level) < size {
+		level++
+	}
+	return level
+}
+
+// join returns the canonical node with the given quadrants.
+func (h *hashLife) join(nw, ne, sw, se *node) *node {
+	key := quad{nw, ne, sw, se}
+	if n, ok := h.nodes[key]; ok {
+		return n
+	}
+	n := &node{
+		nw: nw, ne: ne, sw: sw, se: se,
+		level:      nw.level + 1,
+		population: nw.population + ne.population + sw.population + se.population,
+		results:    make([]*node, nw.level),
+	}
+	h.nodes[key] = n
+	return n
+}
+
+func (h *hashLife) emptyNode(level int) *node {
+	for len(h.empty) <= level {
+		e := h.empty[len(h.empty)-1]
+		h.empty = append(h.empty, h.join(e, e, e, e))
+	}
+	return h.empty[level]
+}
+
+// build creates a node of the given level whose top left cell is (x, y) of the
+// world, repeating the world in both directions.
+func (h *hashLife) build(level, x, y int, world [][]uint8) *node {
+	if level == 0 {
+		if world[y%h.height][x%h.width] == 255 {
+			return h.alive
+		}
+		return h.dead
+	}
+	half := 1 << uint(level-1)
+	return h.join(
+		h.build(level-1, x, y, world),
+		h.build(level-1, x+half, y, world),
+		h.build(level-1, x, y+half, world),
+		h.build(level-1, x+half, y+half, world),
+	)
+}
+
+// flatten writes the alive cells of n into world with n's top left cell at (x, y),
+// skipping anything outside the world.
+func (h *hashLife) flatten(n *node, x, y int, world [][]uint8) {
+	if n.population == 0 || y >= len(world) || x >= len(world[0]) {
+		return
+	}
+	if n.level == 0 {
+		world[y][x] = 255
+		return
+	}
+	half := 1 << uint(n.level-1)
+	h.flatten(n.nw, x, y, world)
+	h.flatten(n.ne, x+half, y, world)
+	h.flatten(n.sw, x, y+half, world)
+	h.flatten(n.se, x+half, y+half, world)
+}
+
+func (h *hashLife) centre(n *node) *node {
+	return h.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+func (h *hashLife) horizontalCentre(w, e *node) *node {
+	return h.join(w.ne, e.nw, w.se, e.sw)
+}
+
+func (h *hashLife) verticalCentre(n, s *node) *node {
+	return h.join(n.sw, n.se, s.nw, s.ne)
+}
+
+// successor returns the centre half of n advanced 2^j turns, where j <= n.level-2.
+func (h *hashLife) successor(n *node, j int) *node {
+	if n.population == 0 {
+		return h.emptyNode(n.level - 1)
+	}
+	if r := n.results[j]; r != nil {
+		return r
+	}
+	var result *node
+	if n.level == 2 {
+		result = h.base(n)
+	} else {
+		sub := [9]*node{
+			n.nw, h.horizontalCentre(n.nw, n.ne), n.ne,
+			h.verticalCentre(n.nw, n.sw), h.centre(n), h.verticalCentre(n.ne, n.se),
+			n.sw, h.horizontalCentre(n.sw, n.se), n.se,
+		}
+		// At full speed the first half of the jump happens here, otherwise the
+		// sub-nodes are just re-centred and the whole jump happens below.
+		next := j
+		if j == n.level-2 {
+			next = n.level - 3
+			for i := range sub {
+				sub[i] = h.successor(sub[i], next)
+			}
+		} else {
+			for i := range sub {
+				sub[i] = h.centre(sub[i])
+			}
+		}
+		result = h.join(
+			h.successor(h.join(sub[0], sub[1], sub[3], sub[4]), next),
+			h.successor(h.join(sub[1], sub[2], sub[4], sub[5]), next),
+			h.successor(h.join(sub[3], sub[4], sub[6], sub[7]), next),
+			h.successor(h.join(sub[4], sub[5], sub[7], sub[8]), next),
+		)
+	}
+	n.results[j] = result
+	return result
+}
+
+// base advances the centre 2x2 cells of a 4x4 node by one turn.
+func (h *hashLife) base(n *node) *node {
+	var cells [4][4]bool
+	for i, q := range [4]*node{n.nw, n.ne, n.sw, n.se} {
+		for k, c := range [4]*node{q.nw, q.ne, q.sw, q.se} {
+			cells[(i/2)*2+k/2][(i%2)*2+k%2] = c.population == 1
+		}
+	}
+	var next [4]*node
+	for i := 0; i < 4; i++ {
+		y, x := 1+i/2, 1+i%2
+		aliveNeighbors := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if !(dx == 0 && dy == 0) && cells[y+dy][x+dx] {
+					aliveNeighbors++
+				}
+			}
+		}
+		var state uint8
+		if cells[y][x] {
+			state = 255
+		}
+		next[i] = h.dead
+		if h.rule.NextState(state, aliveNeighbors) == 255 {
+			next[i] = h.alive
+		}
+	}
+	return h.join(next[0], next[1], next[2], next[3])
+}
+
+// maxStep returns the largest j such that the world can jump 2^j turns at once.
+func (h *hashLife) maxStep() int {
+	if h.square {
+		return h.torus.level - 1
+	}
+	return h.tiledLevel() - 2
+}
+
+// tiledLevel is the level of the tiled node used for boards that are not a square power of two.
+// Its centre half has to hold the whole board.
+func (h *hashLife) tiledLevel() int {
+	size := h.width
+	if h.height > size {
+		size = h.height
+	}
+	level := h.levelFor(size) + 1
+	if level < 3 {
+		level = 3
+	}
+	return level
+}
+
+// step jumps as many turns as possible without passing the final turn, sends
+// the cells that differ and a TurnComplete, and returns the new turn.
+func (h *hashLife) step(c distributorChannels, turn, turns int) int {
+	j := h.maxStep()
+	for j > 0 && 1<<uint(j) > turns-turn {
+		j--
+	}
+	turn += 1 << uint(j)
+	events := newCellEvents(h.p, c, turn)
+
+	if h.square {
+		// Four copies of the torus give a node whose centre, after the jump, is
+		// the new torus shifted by half its size; swapping the quadrants undoes the shift.
+		t := h.torus
+		r := h.successor(h.join(t, t, t, t), j)
+		next := h.join(r.se, r.sw, r.ne, r.nw)
+		h.diff(events, t, next, 0, 0)
+		h.torus = next
+	} else {
+		level := h.tiledLevel()
+		r := h.successor(h.build(level, 0, 0, h.world), j)
+		// r's top left cell is cell (quarter, quarter) of the tiled node, and
+		// its top left width x height cells are one whole copy of the torus.
+		quarter := 1 << uint(level-2)
+		tile := createNewPiece(h.height, h.width)
+		h.flatten(r, 0, 0, tile)
+		next := createNewPiece(h.height, h.width)
+		for y := 0; y < h.height; y++ {
+			for x := 0; x < h.width; x++ {
+				torusX, torusY := (x+quarter)%h.width, (y+quarter)%h.height
+				next[torusY][torusX] = tile[y][x]
+				if next[torusY][torusX] != h.world[torusY][torusX] {
+					events.flip(util.Cell{X: torusX, Y: torusY})
+				}
+			}
+		}
+		h.world = next
+	}
+	events.send()
+
+	if len(h.nodes) > maxHashLifeNodes {
+		world := h.currentWorld()
+		h.reset()
+		if h.square {
+			h.torus = h.build(h.levelFor(h.width), 0, 0, world)
+		}
+	}
+	c.events <- TurnComplete{turn}
+	return turn
+}
+
+// diff records every cell that differs between two nodes, skipping shared subtrees.
+func (h *hashLife) diff(events *cellEvents, before, after *node, x, y int) {
+	if before == after {
+		return
+	}
+	if before.level == 0 {
+		events.flip(util.Cell{X: x, Y: y})
+		return
+	}
+	half := 1 << uint(before.level-1)
+	h.diff(events, before.nw, after.nw, x, y)
+	h.diff(events, before.ne, after.ne, x+half, y)
+	h.diff(events, before.sw, after.sw, x, y+half)
+	h.diff(events, before.se, after.se, x+half, y+half)
+}
+
+func (h *hashLife) aliveCount() int {
+	if h.square {
+		return h.torus.population
+	}
+	return countCell(h.world)
+}
+
+func (h *hashLife) currentWorld() [][]uint8 {
+	if !h.square {
+		return h.world
+	}
+	world := createNewPiece(h.height, h.width)
+	h.flatten(h.torus, 0, 0, world)
+	return world
+}
+
+func (h *hashLife) stop() {}
@@ -0,0 +1,320 @@
+package gol
+
+import "uk.ac.bris.cs/gameoflife/util"
+
+// tileSize is the width and height of the tiles the byte world is split into, so
+// that the workers can skip regions where nothing changed.
+const tileSize = 32
+
+// tileCount returns the number of tiles down and across the byte world.
+func tileCount(p Params) (rows, cols int) {
+	return (p.ImageHeight + tileSize - 1) / tileSize, (p.ImageWidth + tileSize - 1) / tileSize
+}
+
+// workerPool runs the byte world on long-lived workers. Each worker owns a strip
+// of rows for the whole game and swaps only its top and bottom rows with the
+// workers above and below before every turn. The distributor waits for every
+// worker to finish a turn before starting the next, and only puts the world back
+// together when it asks for it.
+type workerPool struct {
+	p        Params
+	workers  []*poolWorker
+	reports  chan poolReport
+	topology util.Topology
+
+	// On the projective plane a cell at a side edge borders the mirrored row,
+	// which may belong to any worker, so the edge columns are shared every turn.
+	left, right []uint8
+}
+
+type poolCommand int
+
+const (
+	poolTurn poolCommand = iota
+	poolCount
+	poolWorld
+	poolStop
+)
+
+type poolRequest struct {
+	command     poolCommand
+	turn        int
+	left, right []uint8
+}
+
+type poolReport struct {
+	index       int
+	count       int
+	rows        [][]uint8
+	left, right []uint8
+}
+
+// poolWorker computes rows startY to endY. current holds its rows of the world and
+// next is written during a turn, then the two are swapped. Only tiles near a cell
+// that changed in the strip, its halo rows or the edge columns are recomputed.
+type poolWorker struct {
+	p                    Params
+	index, startY, endY  int
+	width, height        int
+	rule                 util.Rule
+	topology             util.Topology
+	current, next        [][]uint8
+	above, below         *poolWorker
+	fromAbove, fromBelow chan []uint8
+	requests             chan poolRequest
+
+	changed                                   []bool  // tiles that changed in the last turn, nil before the first
+	lastAbove, lastBelow, lastLeft, lastRight []uint8 // the halo rows and edge columns of the last turn
+}
+
+// newWorkerPool splits the world into p.Threads strips and starts a worker for each.
+func newWorkerPool(p Params, c distributorChannels, world [][]uint8, rule util.Rule, topology util.Topology) *workerPool {
+	threads := p.Threads
+	if threads > p.ImageHeight {
+		threads = p.ImageHeight
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	pool := &workerPool{
+		p:        p,
+		reports:  make(chan poolReport),
+		topology: topology,
+	}
+	for i := 0; i < threads; i++ {
+		startY, endY := i*p.ImageHeight/threads, (i+1)*p.ImageHeight/threads
+		w := &poolWorker{
+			p:        p,
+			index:    i,
+			startY:   startY,
+			endY:     endY,
+			width:    p.ImageWidth,
+			height:   p.ImageHeight,
+			rule:     rule,
+			topology: topology,
+			current:  createNewPiece(endY-startY, p.ImageWidth),
+			next:     createNewPiece(endY-startY, p.ImageWidth),
+			// Each worker receives one row from above and one from below per turn,
+			// so a buffer of one lets every worker send before it receives.
+			fromAbove: make(chan []uint8, 1),
+			fromBelow: make(chan []uint8, 1),
+			requests:  make(chan poolRequest),
+		}
+		for y := startY; y < endY; y++ {
+			copy(w.current[y-startY], world[y])
+		}
+		pool.workers = append(pool.workers, w)
+	}
+	// The strips form a ring; where the top and bottom edges are dead the halo rows are ignored.
+	for i, w := range pool.workers {
+		w.above = pool.workers[(i-1+threads)%threads]
+		w.below = pool.workers[(i+1)%threads]
+	}
+	if topology == util.Projective {
+		pool.left = make([]uint8, p.ImageHeight)
+		pool.right = make([]uint8, p.ImageHeight)
+		for y := 0; y < p.ImageHeight; y++ {
+			pool.left[y] = world[y][0]
+			pool.right[y] = world[y][p.ImageWidth-1]
+		}
+	}
+	for _, w := range pool.workers {
+		go w.run(c, pool.reports)
+	}
+	return pool
+}
+
+func (pool *workerPool) step(c distributorChannels, turn, turns int) int {
+	turn++
+	for _, w := range pool.workers {
+		w.requests <- poolRequest{command: poolTurn, turn: turn, left: pool.left, right: pool.right}
+	}
+	// Barrier: no worker starts the next turn until every worker has finished this one.
+	reports := make([]poolReport, len(pool.workers))
+	for range pool.workers {
+		report := <-pool.reports
+		reports[report.index] = report
+	}
+	if pool.left != nil {
+		for i, w := range pool.workers {
+			copy(pool.left[w.startY:w.endY], reports[i].left)
+			copy(pool.right[w.startY:w.endY], reports[i].right)
+		}
+	}
+	c.events <- TurnComplete{turn}
+	return turn
+}
+
+// collect sends a request to every worker and returns their reports in strip order.
+func (pool *workerPool) collect(command poolCommand) []poolReport {
+	for _, w := range pool.workers {
+		w.requests <- poolRequest{command: command}
+	}
+	reports := make([]poolReport, len(pool.workers))
+	for range pool.workers {
+		report := <-pool.reports
+		reports[report.index] = report
+	}
+	return reports
+}
+
+func (pool *workerPool) aliveCount() int {
+	counter := 0
+	for _, report := range pool.collect(poolCount) {
+		counter += report.count
+	}
+	return counter
+}
+
+func (pool *workerPool) currentWorld() [][]uint8 {
+	world := make([][]uint8, 0, pool.p.ImageHeight)
+	for _, report := range pool.collect(poolWorld) {
+		world = append(world, report.rows...)
+	}
+	return world
+}
+
+func (pool *workerPool) stop() {
+	for _, w := range pool.workers {
+		w.requests <- poolRequest{command: poolStop}
+	}
+}
+
+func (w *poolWorker) run(c distributorChannels, reports chan<- poolReport) {
+	for request := range w.requests {
+		switch request.command {
+		case poolTurn:
+			reports <- w.turn(c, request.turn, request.left, request.right)
+		case poolCount:
+			reports <- poolReport{index: w.index, count: countCell(w.current)}
+		case poolWorld:
+			rows := createNewPiece(w.endY-w.startY, w.width)
+			for y := range rows {
+				copy(rows[y], w.current[y])
+			}
+			reports <- poolReport{index: w.index, rows: rows}
+		case poolStop:
+			return
+		}
+	}
+}
+
+// turn exchanges halo rows with the neighbouring workers and computes the strip's next turn.
+func (w *poolWorker) turn(c distributorChannels, turn int, left, right []uint8) poolReport {
+	// The rows sent are not written again until after the barrier, so they need no copy.
+	w.above.fromBelow <- w.current[0]
+	w.below.fromAbove <- w.current[len(w.current)-1]
+	haloAbove := <-w.fromAbove
+	haloBelow := <-w.fromBelow
+
+	// Every tile is computed in the first turn, after that only tiles near a change.
+	// A tile that is skipped did not change last turn, so next already holds it.
+	rows, cols := tileCount(w.p)
+	active := make([]bool, rows*cols)
+	if w.changed == nil {
+		w.changed = make([]bool, rows*cols)
+		for tile := range active {
+			active[tile] = true
+		}
+	} else {
+		w.markHaloChanges(haloAbove, haloBelow, left, right)
+		active = util.ActiveTiles(w.changed, rows, cols, w.topology)
+		for tile := range w.changed {
+			w.changed[tile] = false
+		}
+	}
+	// The halo rows belong to the neighbours, which write them again next turn.
+	w.lastAbove = append(w.lastAbove[:0], haloAbove...)
+	w.lastBelow = append(w.lastBelow[:0], haloBelow...)
+	w.lastLeft = append(w.lastLeft[:0], left...)
+	w.lastRight = append(w.lastRight[:0], right...)
+
+	events := newCellEvents(w.p, c, turn)
+	for y := w.startY; y < w.endY; y++ {
+		row := w.current[y-w.startY]
+		out := w.next[y-w.startY]
+		for tileX := 0; tileX < w.width; tileX += tileSize {
+			tile := (y/tileSize)*cols + tileX/tileSize
+			if !active[tile] {
+				continue
+			}
+			tileEndX := tileX + tileSize
+			if tileEndX > w.width {
+				tileEndX = w.width
+			}
+			for x := tileX; x < tileEndX; x++ {
+				aliveNeighbors := 0
+				for i := -1; i <= 1; i++ {
+					for j := -1; j <= 1; j++ {
+						if i == 0 && j == 0 {
+							continue
+						}
+						neighborX, neighborY, ok := w.topology.Neighbour(x+j, y+i, w.width, w.height)
+						if ok && w.cell(neighborX, neighborY, haloAbove, haloBelow, left, right) == 255 {
+							aliveNeighbors++
+						}
+					}
+				}
+				out[x] = w.rule.NextState(row[x], aliveNeighbors)
+				if out[x] != row[x] {
+					w.changed[tile] = true
+					events.change(util.Cell{X: x, Y: y}, row[x], out[x])
+				}
+			}
+		}
+	}
+	events.send()
+	w.current, w.next = w.next, w.current
+
+	report := poolReport{index: w.index}
+	if left != nil {
+		report.left = make([]uint8, len(w.current))
+		report.right = make([]uint8, len(w.current))
+		for y, row := range w.current {
+			report.left[y] = row[0]
+			report.right[y] = row[w.width-1]
+		}
+	}
+	return report
+}
+
+// markHaloChanges marks the tiles of the halo rows and edge columns that changed
+// since the last turn, so that the strip's tiles next to them are recomputed.
+func (w *poolWorker) markHaloChanges(haloAbove, haloBelow, left, right []uint8) {
+	_, cols := tileCount(w.p)
+	aboveY := (w.startY - 1 + w.height) % w.height
+	belowY := w.endY % w.height
+	for x := 0; x < w.width; x++ {
+		if haloAbove[x] != w.lastAbove[x] {
+			w.changed[(aboveY/tileSize)*cols+x/tileSize] = true
+		}
+		if haloBelow[x] != w.lastBelow[x] {
+			w.changed[(belowY/tileSize)*cols+x/tileSize] = true
+		}
+	}
+	for y := range left {
+		if left[y] != w.lastLeft[y] {
+			w.changed[(y/tileSize)*cols] = true
+		}
+		if right[y] != w.lastRight[y] {
+			w.changed[(y/tileSize)*cols+cols-1] = true
+		}
+	}
+}
+
+// cell returns the value of cell (x, y) at the start of the turn from the strip,
+// the halo rows or, on the projective plane, the edge columns.
+func (w *poolWorker) cell(x, y int, haloAbove, haloBelow, left, right []uint8) uint8 {
+	switch {
+	case y >= w.startY && y < w.endY:
+		return w.current[y-w.startY][x]
+	case y == (w.startY-1+w.height)%w.height:
+		return haloAbove[x]
+	case y == w.endY%w.height:
+		return haloBelow[x]
+	case x == 0:
+		return left[y]
+	default:
+		return right[y]
+	}
+}
@@ -0,0 +1,329 @@
+package gol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Pattern is a pattern from a pattern file. Cells holds its rows with the values
+// used in the world: 255 for alive, 0 for dead and the rule's grey levels for
+// decay states.
+type Pattern struct {
+	Name     string   // the #N line
+	Comments []string // the #C, #c and #O lines
+	Rule     string   // empty if the file does not give one
+	Cells    [][]uint8
+}
+
+// Width returns the number of columns of the pattern.
+func (pat Pattern) Width() int {
+	if len(pat.Cells) == 0 {
+		return 0
+	}
+	return len(pat.Cells[0])
+}
+
+// Height returns the number of rows of the pattern.
+func (pat Pattern) Height() int {
+	return len(pat.Cells)
+}
+
+// ReadRLE reads a pattern in run length encoded form: # lines, a header line
+// "x = <width>, y = <height>, rule = <rule>" and then runs of cells ended by !.
+// In a two state pattern b is dead and o is alive. Generations patterns use .
+// for dead, A for alive and B onwards for the decay states.
+func ReadRLE(r io.Reader) (Pattern, error) {
+	var pat Pattern
+	scanner := bufio.NewScanner(r)
+	width, height := -1, -1
+	for width == -1 && scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			readRLEComment(&pat, line)
+		default:
+			var err error
+			width, height, err = readRLEHeader(&pat, line)
+			if err != nil {
+				return pat, err
+			}
+		}
+	}
+	if width == -1 {
+		return pat, errors.New("pattern has no x = .., y = .. line")
+	}
+	rule, err := parsePatternRule(pat.Rule)
+	if err != nil {
+		return pat, err
+	}
+
+	pat.Cells = createNewPiece(height, width)
+	x, y, count := 0, 0, 0
+	prefix := 0
+	for scanner.Scan() {
+		for _, char := range strings.TrimSpace(scanner.Text()) {
+			state := -1
+			switch {
+			case char >= '0' && char <= '9':
+				count = count*10 + int(char-'0')
+				if count > width && count > height {
+					return pat, fmt.Errorf("run of %d cells in a %dx%d pattern", count, width, height)
+				}
+				continue
+			case char == '!':
+				return pat, nil
+			case char == '$':
+				if count == 0 {
+					count = 1
+				}
+				if count > height {
+					return pat, fmt.Errorf("run of %d rows in a %dx%d pattern", count, width, height)
+				}
+				x, y, count = 0, y+count, 0
+				continue
+			case char == 'b' || char == '.':
+				state = 0
+			case char == 'o':
+				state = 1
+			case char >= 'p' && char <= 'y':
+				prefix = int(char-'p'+1) * 24
+				continue
+			case char >= 'A' && char <= 'X':
+				state = prefix + int(char-'A') + 1
+			case char == ' ' || char == '\t':
+				continue
+			default:
+				return pat, fmt.Errorf("invalid character %q in pattern", char)
+			}
+			prefix = 0
+			if count == 0 {
+				count = 1
+			}
+			if count > width {
+				return pat, fmt.Errorf("run of %d cells in a %dx%d pattern", count, width, height)
+			}
+			if state >= rule.States {
+				return pat, fmt.Errorf("state %d is not in rule %s", state, rule)
+			}
+			if state != 0 {
+				if y >= height || x+count > width {
+					return pat, fmt.Errorf("pattern is larger than %dx%d", width, height)
+				}
+				value := rule.StateValue(state - 1)
+				for i := 0; i < count; i++ {
+					pat.Cells[y][x+i] = value
+				}
+			}
+			x, count = x+count, 0
+		}
+	}
+	if scanner.Err() != nil {
+		return pat, scanner.Err()
+	}
+	return pat, errors.New("pattern has no ! at the end")
+}
+
+// readRLEComment keeps the name, comments and rule of a # line.
+func readRLEComment(pat *Pattern, line string) {
+	if len(line) < 2 {
+		return
+	}
+	text := strings.TrimSpace(line[2:])
+	switch line[1] {
+	case 'N':
+		pat.Name = text
+	case 'C', 'c', 'O':
+		pat.Comments = append(pat.Comments, text)
+	case 'r':
+		pat.Rule = text
+	}
+}
+
+// readRLEHeader reads the size and rule from a line like "x = 3, y = 3, rule = B3/S23".
+func readRLEHeader(pat *Pattern, line string) (width, height int, err error) {
+	width, height = -1, -1
+	for _, field := range strings.Split(line, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return 0, 0, fmt.Errorf("invalid pattern header %q", line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "x", "y":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return 0, 0, fmt.Errorf("invalid pattern %s %q", key, value)
+			}
+			if key == "x" {
+				width = n
+			} else {
+				height = n
+			}
+		case "rule":
+			pat.Rule = value
+		}
+	}
+	if width == -1 || height == -1 {
+		return 0, 0, fmt.Errorf("invalid pattern header %q", line)
+	}
+	err = checkPatternSize(width, height)
+	if err != nil {
+		return 0, 0, err
+	}
+	return width, height, nil
+}
+
+// maxPatternCells is the most cells a pattern can cover, so a bad header can't
+// use up all the memory before the pattern is checked against the board.
+const maxPatternCells = 1 << 26
+
+// checkPatternSize returns an error if a width x height pattern is too large to load.
+func checkPatternSize(width, height int) error {
+	if width > maxImageSide || height > maxImageSide || width*height > maxPatternCells {
+		return fmt.Errorf("pattern of %dx%d cells is too large", width, height)
+	}
+	return nil
+}
+
+// parsePatternRule parses a rule from a pattern file. As well as the B/S forms
+// of util.ParseRule, pattern files often give rules as S/B or S/B/C, e.g. 23/3.
+func parsePatternRule(rulestring string) (util.Rule, error) {
+	rule, err := util.ParseRule(rulestring)
+	if err == nil {
+		return rule, nil
+	}
+	parts := strings.Split(rulestring, "/")
+	if len(parts) == 2 || len(parts) == 3 {
+		converted := "B" + parts[1] + "/S" + parts[0]
+		if len(parts) == 3 {
+			converted += "/C" + parts[2]
+		}
+		if rule, convertedErr := util.ParseRule(converted); convertedErr == nil {
+			return rule, nil
+		}
+	}
+	return rule, err
+}
+
+// PatternRule returns the rule of a pattern in the B/S form of Params.Rule, or
+// an empty string if the pattern does not give one.
+func PatternRule(pat Pattern) (string, error) {
+	if pat.Rule == "" {
+		return "", nil
+	}
+	rule, err := parsePatternRule(pat.Rule)
+	if err != nil {
+		return "", err
+	}
+	return rule.String(), nil
+}
+
+// WriteRLE writes a pattern in run length encoded form, with lines of at most 70 characters.
+func WriteRLE(w io.Writer, pat Pattern) error {
+	rule, err := parsePatternRule(pat.Rule)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if pat.Name != "" {
+		fmt.Fprintf(bw, "#N %s\n", pat.Name)
+	}
+	for _, comment := range pat.Comments {
+		fmt.Fprintf(bw, "#C %s\n", comment)
+	}
+	fmt.Fprintf(bw, "x = %d, y = %d, rule = %s\n", pat.Width(), pat.Height(), rule)
+
+	line := ""
+	add := func(count int, tag string) {
+		token := tag
+		if count > 1 {
+			token = strconv.Itoa(count) + tag
+		}
+		if len(line)+len(token) > 70 {
+			fmt.Fprintln(bw, line)
+			line = ""
+		}
+		line += token
+	}
+	// Dead cells at the end of a row and empty rows at the end are left out.
+	lastY := 0
+	for y, row := range pat.Cells {
+		end := len(row)
+		for end > 0 && row[end-1] == 0 {
+			end--
+		}
+		if end == 0 {
+			continue
+		}
+		if y > lastY {
+			add(y-lastY, "$")
+		}
+		lastY = y
+		for x := 0; x < end; {
+			run := 1
+			for x+run < end && row[x+run] == row[x] {
+				run++
+			}
+			tag, err := rleTag(rule, row[x])
+			if err != nil {
+				return err
+			}
+			add(run, tag)
+			x += run
+		}
+	}
+	add(1, "!")
+	fmt.Fprintln(bw, line)
+	return bw.Flush()
+}
+
+// rleTag returns the letters of a cell value in a pattern with the given rule.
+func rleTag(rule util.Rule, value uint8) (string, error) {
+	if rule.States == 2 {
+		switch value {
+		case 0:
+			return "b", nil
+		case 255:
+			return "o", nil
+		}
+		return "", fmt.Errorf("cell value %d is not in rule %s", value, rule)
+	}
+	if value == 0 {
+		return ".", nil
+	}
+	for k := 0; k < rule.States-1; k++ {
+		if rule.StateValue(k) == value {
+			state := k + 1
+			tag := string(rune('A' + (state-1)%24))
+			if state > 24 {
+				tag = string(rune('p'+(state-1)/24-1)) + tag
+			}
+			return tag, nil
+		}
+	}
+	return "", fmt.Errorf("cell value %d is not in rule %s", value, rule)
+}
+
+// placePattern puts a pattern on a width x height board with its top left cell
+// at at, or in the middle of the board if at is nil.
+func placePattern(pat Pattern, width, height int, at *util.Cell) ([][]uint8, error) {
+	x, y := (width-pat.Width())/2, (height-pat.Height())/2
+	if at != nil {
+		x, y = at.X, at.Y
+	}
+	if x < 0 || y < 0 || x+pat.Width() > width || y+pat.Height() > height {
+		return nil, fmt.Errorf("the %dx%d pattern does not fit on the %dx%d board at (%d, %d)",
+			pat.Width(), pat.Height(), width, height, x, y)
+	}
+	world := createNewPiece(height, width)
+	for py, row := range pat.Cells {
+		copy(world[y+py][x:], row)
+	}
+	return world, nil
+}
@@ -0,0 +1,142 @@
+package gol
+
+import "uk.ac.bris.cs/gameoflife/util"
+
+// sharedPool runs the byte world on long-lived workers that share it. Every worker
+// reads the whole front buffer and writes its strip straight into the back buffer;
+// the buffers are swapped once all workers are done. Only tiles that changed last
+// turn or border one are recomputed.
+type sharedPool struct {
+	p           Params
+	front, back [][]uint8
+	active      []bool
+	changed     []bool      // the tiles that changed this turn
+	starts      []chan int  // each worker's next turn; closed to stop it
+	done        chan []bool // the tiles a worker changed
+	rule        util.Rule
+	topology    util.Topology
+}
+
+// newSharedPool splits the world into p.Threads strips and starts a worker for each.
+func newSharedPool(p Params, c distributorChannels, world [][]uint8, rule util.Rule, topology util.Topology) *sharedPool {
+	threads := p.Threads
+	if threads > p.ImageHeight {
+		threads = p.ImageHeight
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	rows, cols := tileCount(p)
+	active := make([]bool, rows*cols)
+	for tile := range active {
+		active[tile] = true
+	}
+	pool := &sharedPool{
+		p:        p,
+		front:    world,
+		back:     createNewWorld(p),
+		active:   active,
+		changed:  make([]bool, rows*cols),
+		done:     make(chan []bool),
+		rule:     rule,
+		topology: topology,
+	}
+	for i := 0; i < threads; i++ {
+		start := make(chan int)
+		pool.starts = append(pool.starts, start)
+		go pool.worker(c, i*p.ImageHeight/threads, (i+1)*p.ImageHeight/threads, start)
+	}
+	return pool
+}
+
+// worker computes rows startY to endY every time it is given a turn. The buffers are
+// only swapped while every worker is waiting, so they can be read without a lock.
+// A tile that is not active did not change last turn, so the back buffer already
+// holds it. Strips may share a row of tiles, so every worker marks its own changes.
+func (pool *sharedPool) worker(c distributorChannels, startY, endY int, start <-chan int) {
+	_, cols := tileCount(pool.p)
+	changed := make([]bool, len(pool.changed))
+	for turn := range start {
+		for tile := range changed {
+			changed[tile] = false
+		}
+		events := newCellEvents(pool.p, c, turn)
+		for y := startY; y < endY; y++ {
+			row := pool.front[y]
+			out := pool.back[y]
+			for tileX := 0; tileX < len(row); tileX += tileSize {
+				tile := (y/tileSize)*cols + tileX/tileSize
+				if !pool.active[tile] {
+					continue
+				}
+				tileEndX := tileX + tileSize
+				if tileEndX > len(row) {
+					tileEndX = len(row)
+				}
+				for x := tileX; x < tileEndX; x++ {
+					out[x] = pool.rule.NextState(row[x], countAliveNeighbors(x, y, pool.p, pool.front, pool.topology))
+					if out[x] != row[x] {
+						changed[tile] = true
+						events.change(util.Cell{X: x, Y: y}, row[x], out[x])
+					}
+				}
+			}
+		}
+		events.send()
+		pool.done <- changed
+	}
+}
+
+func (pool *sharedPool) step(c distributorChannels, turn, turns int) int {
+	turn++
+	for _, start := range pool.starts {
+		start <- turn
+	}
+	for tile := range pool.changed {
+		pool.changed[tile] = false
+	}
+	// Barrier: a worker's changes are only read until it is given the next turn.
+	for range pool.starts {
+		for tile, tileChanged := range <-pool.done {
+			pool.changed[tile] = pool.changed[tile] || tileChanged
+		}
+	}
+	pool.front, pool.back = pool.back, pool.front
+	rows, cols := tileCount(pool.p)
+	pool.active = util.ActiveTiles(pool.changed, rows, cols, pool.topology)
+	c.events <- TurnComplete{turn}
+	return turn
+}
+
+func (pool *sharedPool) aliveCount() int {
+	return countCell(pool.front)
+}
+
+func (pool *sharedPool) currentWorld() [][]uint8 {
+	world := createNewWorld(pool.p)
+	for y := range world {
+		copy(world[y], pool.front[y])
+	}
+	return world
+}
+
+func (pool *sharedPool) stop() {
+	for _, start := range pool.starts {
+		close(start)
+	}
+}
+
+func countAliveNeighbors(x, y int, p Params, world [][]uint8, topology util.Topology) int {
+	alive := 0
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			if !(i == 0 && j == 0) {
+				neighborX, neighborY, ok := topology.Neighbour(x+j, y+i, p.ImageWidth, p.ImageHeight)
+				if ok && world[neighborY][neighborX] == 255 {
+					alive++
+				}
+			}
+		}
+	}
+	return alive
+}
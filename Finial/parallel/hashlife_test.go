@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestHashLife tests the HashLife engine against the same images as TestGol and TestGolOddDimensions.
+func TestHashLife(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+		{ImageWidth: 100, ImageHeight: 75},
+		{ImageWidth: 33, ImageHeight: 17},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			p.Engine = "hashlife"
+			expectedAlive := readAliveCells(
+				"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			testName := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns)
+			t.Run(testName, func(t *testing.T) {
+				assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+			})
+		}
+	}
+}
+
+// TestHashLifeMatchesDistributor compares HashLife with the default engine over turn counts that are not powers of two.
+func TestHashLifeMatchesDistributor(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 64, ImageHeight: 64, Turns: 1237},
+		{ImageWidth: 64, ImageHeight: 64, Turns: 555, Rule: "B36/S23"},
+		{ImageWidth: 100, ImageHeight: 75, Turns: 777},
+		{ImageWidth: 16, ImageHeight: 16, Turns: 3001, Rule: "B3678/S34678"},
+	}
+	for _, p := range tests {
+		p.Threads = 4
+		testName := fmt.Sprintf("%s-%dx%dx%d", p.Rule, p.ImageWidth, p.ImageHeight, p.Turns)
+		t.Run(testName, func(t *testing.T) {
+			expectedAlive := runToFinalTurn(p)
+			p.Engine = "hashlife"
+			assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+		})
+	}
+}
+
+// TestHashLifeLongRun runs the 512x512 image for a billion turns, after which it oscillates between 5565 and 5567 alive cells.
+func TestHashLifeLongRun(t *testing.T) {
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 1000000000, Engine: "hashlife"}
+	alive := runToFinalTurn(p)
+	if len(alive) != 5565 {
+		t.Errorf("Expected 5565 alive cells after %d turns, got %d", p.Turns, len(alive))
+	}
+}
+
+func runToFinalTurn(p gol.Params) []util.Cell {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var cells []util.Cell
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	return cells
+}
+
+// TestHashLifeRejects checks that games HashLife can't run exactly, with decay states, another
+// topology or cells born with no neighbours, end with Quitting instead of a final turn.
+func TestHashLifeRejects(t *testing.T) {
+	tests := map[string]gol.Params{
+		"decay states": {Rule: "B2/S/C3"},
+		"Klein bottle": {Topology: "klein"},
+		"B0/S8":        {Rule: "B0/S8"},
+		"B03/S23":      {Rule: "B03/S23"},
+	}
+	for name, p := range tests {
+		p.ImageWidth, p.ImageHeight, p.Turns, p.Engine = 64, 64, 10, "hashlife"
+		events := make(chan gol.Event)
+		go gol.Run(p, events, nil)
+		quitting := false
+		for event := range events {
+			switch e := event.(type) {
+			case gol.FinalTurnComplete:
+				t.Errorf("%s: expected HashLife to refuse the game, got a final turn", name)
+			case gol.StateChange:
+				quitting = e.NewState == gol.Quitting
+			}
+		}
+		if !quitting {
+			t.Errorf("%s: expected the game to end with Quitting", name)
+		}
+	}
+}
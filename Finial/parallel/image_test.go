@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// glider is the glider every image in imageSeeds holds.
+var glider = [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+
+// imageSeeds holds the glider in each netpbm format.
+var imageSeeds = map[string]string{
+	"P1":             "P1\n# a glider\n3 3\n010\n0 0 1\n111\n",
+	"P2":             "P2 3 3 15\n0 15 0\n# comment between rows\n0 0 15\n15 15 15\n",
+	"P4":             "P4\n3 3\n\x40\x20\xe0",
+	"P5":             "P5\n# made by hand\n3 3\n255\n\x00\xff\x00\x00\x00\xff\xff\xff\xff",
+	"P5 16-bit":      "P5 3 3 65535\n\x00\x00\xff\xff\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff\xff\xff\xff\xff",
+	"P5 whitespace":  "P5 3 3 32\n\x0a\x20\x09\x0d\x0a\x20\x20\x20\x20",
+	"P5 grey levels": "P5 3 3 255\n\x10\x90\x7f\x00\x20\x81\xc0\xd0\xe0",
+}
+
+// TestImageFormats reads the glider in every netpbm format and the check images as they were
+// written, and rejects broken images with an error.
+func TestImageFormats(t *testing.T) {
+	rule, err := util.ParseRule(util.DefaultRule)
+	util.Check(err)
+	for name, image := range imageSeeds {
+		cells, err := gol.ReadImage(strings.NewReader(image), rule)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(cells, glider) {
+			t.Errorf("%s: expected the glider %v, got %v", name, glider, cells)
+		}
+	}
+
+	images := []struct {
+		path          string
+		width, height int
+		rule          string
+	}{
+		{"check/images/512x512x100.pgm", 512, 512, "B3/S23"},
+		{"check/images/100x75x100-B2SC3.pgm", 100, 75, "B2/S/C3"},
+		{"check/images/100x75x100-B2S345C4.pgm", 100, 75, "B2/S345/C4"},
+	}
+	for _, test := range images {
+		rule, err := util.ParseRule(test.rule)
+		util.Check(err)
+		data, err := ioutil.ReadFile(test.path)
+		util.Check(err)
+		cells, err := gol.ReadImage(bytes.NewReader(data), rule)
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if !reflect.DeepEqual(cells, readCellValues(test.path, test.width, test.height)) {
+			t.Errorf("%s was not read as it was written", test.path)
+		}
+	}
+
+	broken := []string{
+		"",
+		"P3 3 3 255\n0 0 0",
+		"P5 3 3\n",
+		"P5 0 3 255\n",
+		"P5 3 3 0\n",
+		"P5 3 3 255\n\x00\xff",
+		"P5 3 3 255x\x00\xff\x00\x00\x00\xff\xff\xff\xff",
+		"P2 3 3 15\n0 16 0 0 0 15 15 15 15",
+		"P1 3 3 012 001 111",
+		"P2 3 3 15\n0 15 0 0 0 15 15 15",
+		"P4 100000 1\n",
+	}
+	for _, image := range broken {
+		_, err := gol.ReadImage(strings.NewReader(image), rule)
+		if err == nil {
+			t.Errorf("Expected %q to be rejected", image)
+		}
+	}
+}
+
+// TestLoadErrors runs games from a truncated image, an image of the wrong size, a broken
+// pattern, a checkpoint of another size, with a bad rule and with an unknown engine. Each
+// game has to stop with Quitting and close its events without a final turn.
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol-load")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	truncated := filepath.Join(dir, "truncated.pgm")
+	util.Check(ioutil.WriteFile(truncated, []byte("P5 33 17 255\n\x00\xff"), 0644))
+	broken := filepath.Join(dir, "broken.rle")
+	util.Check(ioutil.WriteFile(broken, []byte("x = 3, y = 3\nbzb$2bo$3o!"), 0644))
+	checkpoint := filepath.Join(dir, "checkpoint")
+	util.Check(gol.WriteCheckpoint(checkpoint, gol.Checkpoint{
+		Params: gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 10},
+		Turn:   5,
+		World:  readCellValues("images/16x16.pgm", 16, 16),
+	}))
+
+	tests := map[string]gol.Params{
+		"truncated image":  {Turns: 10, Threads: 4, InputPath: truncated},
+		"wrong size":       {Turns: 10, Threads: 4, ImageWidth: 16, ImageHeight: 16, InputPath: "images/33x17.pgm"},
+		"broken pattern":   {Turns: 10, Threads: 4, ImageWidth: 16, ImageHeight: 16, Pattern: broken},
+		"wrong checkpoint": {Turns: 10, Threads: 4, ImageWidth: 64, ImageHeight: 64, Resume: checkpoint},
+		"bad rule":         {Turns: 10, Threads: 4, ImageWidth: 16, ImageHeight: 16, Rule: "B9/S23"},
+		"unknown engine":   {Turns: 10, Threads: 4, ImageWidth: 16, ImageHeight: 16, Engine: "nonsense"},
+	}
+	for name, p := range tests {
+		events := make(chan gol.Event, 1000)
+		go gol.Run(p, events, nil)
+		quitting := false
+		for event := range events {
+			switch e := event.(type) {
+			case gol.FinalTurnComplete:
+				t.Errorf("%s: expected no final turn, got one at turn %d", name, e.CompletedTurns)
+			case gol.StateChange:
+				quitting = e.NewState == gol.Quitting
+			}
+		}
+		if !quitting {
+			t.Errorf("%s: expected the game to end with Quitting", name)
+		}
+	}
+}
+
+// FuzzReadImage checks that any input is either rejected or read as rows of the size in its
+// header, with every cell alive or dead.
+func FuzzReadImage(f *testing.F) {
+	for _, image := range imageSeeds {
+		f.Add([]byte(image))
+	}
+	rule, err := util.ParseRule(util.DefaultRule)
+	util.Check(err)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cells, err := gol.ReadImage(bytes.NewReader(data), rule)
+		if err != nil {
+			return
+		}
+		if len(cells) == 0 {
+			t.Fatalf("Expected an error for an image with no rows")
+		}
+		for _, row := range cells {
+			if len(row) != len(cells[0]) {
+				t.Fatalf("Rows of %d and %d cells", len(cells[0]), len(row))
+			}
+			for _, value := range row {
+				if value != 0 && value != 255 {
+					t.Fatalf("Cell value %d with rule %s", value, rule)
+				}
+			}
+		}
+	})
+}
+
+// FuzzReadRLE checks that any input is either rejected or read as a pattern of the size in its
+// header, without a panic from a run past the edge of the pattern.
+func FuzzReadRLE(f *testing.F) {
+	f.Add([]byte(gliderRLE))
+	f.Add([]byte("x = 3, y = 3, rule = B2/S/C30\n.A.$2.B$3C!"))
+	f.Add([]byte("x = 3, y = 3\n18446744073709551615b2o!"))
+	f.Add([]byte("x = 3, y = 3\n18446744073709551615$2o!"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pattern, err := gol.ReadRLE(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for _, row := range pattern.Cells {
+			if len(row) != pattern.Width() {
+				t.Fatalf("Rows of %d and %d cells", pattern.Width(), len(row))
+			}
+		}
+	})
+}
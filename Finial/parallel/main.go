@@ -3,10 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
+	"time"
 
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -38,6 +41,107 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.StringVar(
+		&params.Rule,
+		"rule",
+		util.DefaultRule,
+		"Specify the rule in B/S notation, e.g. B36/S23, or B/S/C for Generations rules, e.g. B2/S/C3. Defaults to B3/S23.")
+
+	flag.StringVar(
+		&params.Topology,
+		"topology",
+		"torus",
+		"Specify how the board edges are joined: torus, plane, cylinder, vcylinder, klein or projective. Defaults to torus.")
+
+	flag.StringVar(
+		&params.Engine,
+		"engine",
+		"",
+		"Specify \"bytes\" to run one byte per cell on a worker pool or \"hashlife\" to jump many turns at once with HashLife. Defaults to a bit-packed board for two-state rules.")
+
+	flag.StringVar(
+		&params.Workers,
+		"workers",
+		"halo",
+		"Specify how byte world workers see the board: \"halo\" to own a strip and exchange edge rows or \"shared\" to read one shared board. Defaults to halo.")
+
+	flag.StringVar(
+		&params.Checkpoint,
+		"checkpoint",
+		"",
+		"Specify a file to save the game to every so often, so it can be carried on with -resume. Defaults to no checkpoints.")
+
+	flag.DurationVar(
+		&params.CheckpointEvery,
+		"checkpointEvery",
+		10*time.Minute,
+		"Specify the time between checkpoints. Defaults to 10m.")
+
+	flag.StringVar(
+		&params.Resume,
+		"resume",
+		"",
+		"Specify a checkpoint to carry on from. Its size, rule, topology and turns are used unless -turns is given.")
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify a pattern file to start from instead of the image: RLE, plaintext (.cells), Life 1.05 or Life 1.06. Its rule is used unless -rule is given.")
+
+	patternAt := flag.String(
+		"patternAt",
+		"",
+		"Specify where the pattern's top left cell goes as x,y. Defaults to the middle of the board.")
+
+	flag.StringVar(
+		&params.InputPath,
+		"in",
+		"",
+		"Specify a pgm image to load. Its width and height are used instead of -w and -h. Defaults to images/<w>x<h>.pgm.")
+
+	flag.StringVar(
+		&params.OutputDir,
+		"out",
+		"out",
+		"Specify the directory images are saved to. Defaults to out.")
+
+	gifPath := flag.String(
+		"gif",
+		"",
+		"Specify a file to record the game to as an animated GIF. Defaults to no recording.")
+
+	var record gol.RecordOptions
+	flag.IntVar(
+		&record.Every,
+		"gifEvery",
+		1,
+		"Specify the number of turns between GIF frames. Defaults to 1.")
+
+	flag.IntVar(
+		&record.Scale,
+		"gifScale",
+		1,
+		"Specify the number of GIF pixels per cell. Defaults to 1.")
+
+	flag.DurationVar(
+		&record.Delay,
+		"gifDelay",
+		100*time.Millisecond,
+		"Specify the time each GIF frame is shown. Defaults to 100ms.")
+
+	flag.IntVar(
+		&record.From,
+		"gifFrom",
+		0,
+		"Specify the first turn recorded in the GIF. Defaults to 0.")
+
+	flag.IntVar(
+		&record.To,
+		"gifTo",
+		0,
+		"Specify the last turn recorded in the GIF. Defaults to the end of the game.")
+
 	noVis := flag.Bool(
 		"noVis",
 		false,
@@ -45,24 +149,131 @@ func main() {
 
 	flag.Parse()
 
+	if params.InputPath != "" {
+		width, height, err := gol.ImageSize(params.InputPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		params.ImageWidth, params.ImageHeight = width, height
+		fmt.Println("Image:", params.InputPath)
+	}
+
+	if params.Resume != "" {
+		cp, err := gol.ReadCheckpoint(params.Resume)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		turnsSet := false
+		flag.Visit(func(f *flag.Flag) {
+			turnsSet = turnsSet || f.Name == "turns"
+		})
+		params.ImageWidth = cp.Params.ImageWidth
+		params.ImageHeight = cp.Params.ImageHeight
+		params.Rule = cp.Params.Rule
+		params.Topology = cp.Params.Topology
+		if !turnsSet {
+			params.Turns = cp.Params.Turns
+		}
+		fmt.Println("Resuming from turn", cp.Turn, "of", params.Resume)
+	}
+
+	if params.Pattern != "" {
+		err := usePattern(&params, *patternAt)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := util.ParseRule(params.Rule); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := util.ParseTopology(params.Topology); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
+	fmt.Println("Rule:", params.Rule)
+	fmt.Println("Topology:", params.Topology)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
 
+	var shown <-chan gol.Event = events
+	var recorder *gol.Recorder
+	if *gifPath != "" {
+		// Frames are written as they are recorded, so the file is made before the game starts.
+		file, err := os.Create(*gifPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		recorder = gol.NewRecorder(file, params.ImageWidth, params.ImageHeight, record)
+		shown = recorder.Record(events)
+	}
+
 	go gol.Run(params, events, keyPresses)
 	if !(*noVis) {
-		sdl.Run(params, events, keyPresses)
-	} else {
-		complete := false
-		for !complete {
-			event := <-events
-			switch event.(type) {
-			case gol.FinalTurnComplete:
-				complete = true
-			}
+		sdl.Run(params, shown, keyPresses)
+	}
+	// gol.Run closes events once the final turn is done or q is pressed.
+	for range shown {
+	}
+
+	if recorder != nil {
+		err := recorder.Close()
+		if err != nil {
+			fmt.Printf("%s: %v\n", *gifPath, err)
+			os.Exit(1)
+		}
+		fmt.Println("Recorded", recorder.Frames(), "frames to", *gifPath)
+	}
+}
+
+// usePattern checks that the pattern fits on the board and takes its rule, unless
+// -rule was given. at is where its top left cell goes as x,y, or empty to centre it.
+func usePattern(params *gol.Params, at string) error {
+	file, err := os.Open(params.Pattern)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	pattern, format, err := gol.ReadPattern(file, params.PatternFormat)
+	if err != nil {
+		return fmt.Errorf("%s: %v", params.Pattern, err)
+	}
+
+	ruleSet := false
+	flag.Visit(func(f *flag.Flag) {
+		ruleSet = ruleSet || f.Name == "rule"
+	})
+	rule, err := gol.PatternRule(pattern)
+	if err != nil {
+		return fmt.Errorf("%s: %v", params.Pattern, err)
+	}
+	if !ruleSet && rule != "" {
+		params.Rule = rule
+	}
+
+	x, y := (params.ImageWidth-pattern.Width())/2, (params.ImageHeight-pattern.Height())/2
+	if at != "" {
+		_, err = fmt.Sscanf(at, "%d,%d", &x, &y)
+		if err != nil {
+			return fmt.Errorf("invalid -patternAt %q: expected x,y", at)
 		}
+		params.PatternAt = &util.Cell{X: x, Y: y}
+	}
+	if x < 0 || y < 0 || x+pattern.Width() > params.ImageWidth || y+pattern.Height() > params.ImageHeight {
+		return fmt.Errorf("the %dx%d pattern %s does not fit on the %dx%d board at (%d, %d)",
+			pattern.Width(), pattern.Height(), params.Pattern, params.ImageWidth, params.ImageHeight, x, y)
 	}
+	fmt.Println("Pattern:", params.Pattern, "("+format.String()+")")
+	return nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPaths loads the 33x17 image from a file of another name without giving its size and saves
+// the final world to another directory. The size has to be read from the image.
+func TestPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol-paths")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("images/33x17.pgm")
+	util.Check(err)
+	input := filepath.Join(dir, "board.pgm")
+	util.Check(ioutil.WriteFile(input, data, 0644))
+	output := filepath.Join(dir, "saved")
+
+	width, height, err := gol.ImageSize(input)
+	if err != nil || width != 33 || height != 17 {
+		t.Errorf("Expected the size of %s to be 33x17, got %dx%d (%v)", input, width, height, err)
+	}
+	_, _, err = gol.ImageSize("check/alive/16x16.csv")
+	if err == nil {
+		t.Errorf("Expected a csv file to be rejected as an image")
+	}
+
+	p := gol.Params{Turns: 100, Threads: 4, InputPath: input, OutputDir: output}
+	expected := readAliveCells("check/images/33x17x100.pgm", 33, 17)
+	assertEqualBoard(t, runToFinalTurn(p), expected, p)
+
+	saved := filepath.Join(output, "33x17x100.pgm")
+	if _, err := os.Stat(saved); err != nil {
+		t.Fatalf("Expected the final world to be saved as %s: %v", saved, err)
+	}
+	assertEqualBoard(t, readAliveCells(saved, 33, 17), expected, p)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestWorkerPool tests both byte world worker modes and the bit-packed workers on 0, 1 and 100 turns
+// using 1-16 worker threads, including more threads than some strips have rows, and on every
+// topology using 1, 4 and 8 threads.
+func TestWorkerPool(t *testing.T) {
+	for _, workers := range []string{"halo", "shared", "packed"} {
+		testWorkerPool(t, workers)
+	}
+}
+
+func testWorkerPool(t *testing.T, workers string) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 33, ImageHeight: 17},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			p.Engine, p.Workers = workerEngine(workers)
+			expectedAlive := readAliveCells(
+				"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			for threads := 1; threads <= 16; threads++ {
+				p.Threads = threads
+				testName := fmt.Sprintf("%s-%dx%dx%d-%d", workers, p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+				t.Run(testName, func(t *testing.T) {
+					assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+				})
+			}
+		}
+	}
+
+	for _, topology := range []string{"plane", "cylinder", "vcylinder", "klein", "projective"} {
+		p := gol.Params{ImageWidth: 100, ImageHeight: 75, Turns: 100, Topology: topology}
+		p.Engine, p.Workers = workerEngine(workers)
+		expectedAlive := readAliveCells(
+			"check/images/"+fmt.Sprintf("%vx%vx%v-%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns, topology),
+			p.ImageWidth,
+			p.ImageHeight,
+		)
+		for _, threads := range []int{1, 4, 8} {
+			p.Threads = threads
+			testName := fmt.Sprintf("%s-%s-%dx%dx%d-%d", workers, p.Topology, p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+			t.Run(testName, func(t *testing.T) {
+				assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+			})
+		}
+	}
+}
+
+// TestSparseWorkers runs the sparse 512x512 soup, where most tiles are skipped, on both byte world
+// worker modes for 1 and 100 turns using 1, 5 and 16 threads, so that strips also end inside a tile.
+func TestSparseWorkers(t *testing.T) {
+	for _, workers := range []string{"halo", "shared"} {
+		for _, turns := range []int{1, 100} {
+			p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: turns, Engine: "bytes", Workers: workers}
+			expectedAlive := readAliveCells(fmt.Sprintf("check/images/512x512x%d.pgm", turns), p.ImageWidth, p.ImageHeight)
+			for _, threads := range []int{1, 5, 16} {
+				p.Threads = threads
+				t.Run(fmt.Sprintf("%s-%d-%d", workers, turns, threads), func(t *testing.T) {
+					assertEqualBoard(t, runToFinalTurn(p), expectedAlive, p)
+				})
+			}
+		}
+	}
+}
+
+// workerEngine returns the engine and worker mode of Params that run on the given workers.
+func workerEngine(workers string) (string, string) {
+	if workers == "packed" {
+		return "", ""
+	}
+	return "bytes", workers
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestQuit presses q after the 10th turn of the 512x512 image on every engine. gol.Run has to
+// save the world, send Quitting, close the events channel and return.
+func TestQuit(t *testing.T) {
+	tests := []gol.Params{
+		{},
+		{Engine: "bytes"},
+		{Engine: "bytes", Workers: "shared"},
+		{Engine: "hashlife"},
+	}
+	for _, p := range tests {
+		p.ImageWidth, p.ImageHeight, p.Turns, p.Threads = 512, 512, 10000000000, 8
+		t.Run(fmt.Sprintf("engine=%s-workers=%s", p.Engine, p.Workers), func(t *testing.T) {
+			events := make(chan gol.Event)
+			keyPresses := make(chan rune, 1)
+			ran := make(chan bool)
+			go func() {
+				gol.Run(p, events, keyPresses)
+				close(ran)
+			}()
+			pressed := false
+			var last gol.Event
+			for event := range events {
+				if e, ok := event.(gol.TurnComplete); ok && e.CompletedTurns >= 10 && !pressed {
+					keyPresses <- 'q'
+					pressed = true
+				}
+				last = event
+			}
+			state, ok := last.(gol.StateChange)
+			if !ok || state.NewState != gol.Quitting {
+				t.Fatalf("Expected the last event to be Quitting, got %v", last)
+			}
+			select {
+			case <-ran:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("gol.Run did not return after q")
+			}
+			_, err := os.Stat(fmt.Sprintf("out/output_%d.pgm", state.CompletedTurns))
+			if err != nil {
+				t.Errorf("Expected the world to be saved when quitting: %v", err)
+			}
+		})
+	}
+}
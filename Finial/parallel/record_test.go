@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestRecord records games to animated GIFs and checks that the frames are the check images of
+// their turns, drawn at the given scale. The frames have to be written before the GIF is closed.
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		params  gol.Params
+		options gol.RecordOptions
+		frames  map[int]string // the check image of some of the frames
+		count   int
+	}{
+		{
+			gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 100, Threads: 4},
+			gol.RecordOptions{Every: 50, Scale: 2, Delay: 50 * time.Millisecond},
+			map[int]string{0: "check/images/16x16x0.pgm", 2: "check/images/16x16x100.pgm"},
+			3,
+		},
+		{
+			gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100, Threads: 4},
+			gol.RecordOptions{Every: 1, Scale: 1, From: 1, To: 1},
+			map[int]string{0: "check/images/64x64x1.pgm"},
+			1,
+		},
+		{
+			gol.Params{ImageWidth: 100, ImageHeight: 75, Turns: 100, Threads: 4, Rule: "B2/S/C3"},
+			gol.RecordOptions{Every: 10, Scale: 3, From: 100},
+			map[int]string{0: "check/images/100x75x100-B2SC3.pgm"},
+			1,
+		},
+	}
+	for _, test := range tests {
+		p := test.params
+		var buffer bytes.Buffer
+		recorder := gol.NewRecorder(&buffer, p.ImageWidth, p.ImageHeight, test.options)
+		events := make(chan gol.Event)
+		go gol.Run(p, events, nil)
+		for range recorder.Record(events) {
+		}
+
+		if buffer.Len() == 0 {
+			t.Errorf("Expected the frames to be written as they were recorded")
+		}
+		err := recorder.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		anim, err := gif.DecodeAll(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(anim.Image) != test.count {
+			t.Errorf("Expected %d frames, got %d", test.count, len(anim.Image))
+			continue
+		}
+		scale := test.options.Scale
+		for _, delay := range anim.Delay {
+			if delay != int(test.options.Delay/(10*time.Millisecond)) {
+				t.Errorf("Expected frames of %v, got %d0ms", test.options.Delay, delay)
+			}
+		}
+		for frame, path := range test.frames {
+			img := anim.Image[frame]
+			if img.Bounds().Dx() != p.ImageWidth*scale || img.Bounds().Dy() != p.ImageHeight*scale {
+				t.Fatalf("Expected %dx%d frames, got %v", p.ImageWidth*scale, p.ImageHeight*scale, img.Bounds())
+			}
+			expected := readCellValues(path, p.ImageWidth, p.ImageHeight)
+		check:
+			for y := 0; y < p.ImageHeight*scale; y++ {
+				for x := 0; x < p.ImageWidth*scale; x++ {
+					grey, _, _, _ := img.At(x, y).RGBA()
+					if uint8(grey>>8) != expected[y/scale][x/scale] {
+						t.Errorf("Frame %d is not %s: pixel (%d, %d) is %d", frame, path, x, y, grey>>8)
+						break check
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+const gliderRLE = `#N Glider
+#C The smallest spaceship.
+x = 3, y = 3, rule = 23/3
+bob$2bo$
+3o!
+`
+
+// TestRLE reads and writes RLE patterns and runs a glider loaded from one.
+func TestRLE(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		pattern, err := gol.ReadRLE(strings.NewReader(gliderRLE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern.Name != "Glider" || len(pattern.Comments) != 1 || pattern.Rule != "23/3" {
+			t.Errorf("Expected the glider's name, comment and rule, got %q, %q and %q", pattern.Name, pattern.Comments, pattern.Rule)
+		}
+		rule, err := gol.PatternRule(pattern)
+		if err != nil || rule != "B3/S23" {
+			t.Errorf("Expected rule 23/3 to be B3/S23, got %q (%v)", rule, err)
+		}
+		expected := [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+		if !reflect.DeepEqual(pattern.Cells, expected) {
+			t.Errorf("Expected the glider's cells %v, got %v", expected, pattern.Cells)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		generations, err := util.ParseRule("B2/S/C30")
+		util.Check(err)
+		decaying := make([][]uint8, 40)
+		for y := range decaying {
+			decaying[y] = make([]uint8, 50)
+			for x := range decaying[y] {
+				if (x*7+y*3)%5 != 0 {
+					decaying[y][x] = generations.StateValue((x + y) % generations.States)
+				}
+			}
+		}
+		decaying[39] = make([]uint8, 50)
+		tests := []gol.Pattern{
+			{Name: "512x512x100", Rule: "B3/S23", Cells: readCellValues("check/images/512x512x100.pgm", 512, 512)},
+			{Comments: []string{"Every decay state"}, Rule: "B2/S/C30", Cells: decaying},
+		}
+		for _, pattern := range tests {
+			var buffer bytes.Buffer
+			util.Check(gol.WriteRLE(&buffer, pattern))
+			for _, line := range strings.Split(buffer.String(), "\n") {
+				if len(line) > 70 && !strings.HasPrefix(line, "#") {
+					t.Errorf("Line of %d characters: %q", len(line), line)
+				}
+			}
+			read, err := gol.ReadRLE(&buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.Name != pattern.Name || !reflect.DeepEqual(read.Comments, pattern.Comments) || read.Rule != pattern.Rule {
+				t.Errorf("Expected name %q, comments %q and rule %q, got %q, %q and %q",
+					pattern.Name, pattern.Comments, pattern.Rule, read.Name, read.Comments, read.Rule)
+			}
+			if !reflect.DeepEqual(read.Cells, pattern.Cells) {
+				t.Errorf("The cells of the %s pattern changed when written and read", pattern.Rule)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := map[string]string{
+			"no header":     "bo$2bo$3o!",
+			"too wide":      "x = 2, y = 3\nbob$2bo$3o!",
+			"no end":        "x = 3, y = 3\nbob$2bo$3o",
+			"bad character": "x = 3, y = 3\nbzb$2bo$3o!",
+			"bad state":     "x = 3, y = 3\n.C.$2.A$3A!",
+			"huge header":   "x = 1000000000, y = 1000000000\n!",
+			"huge run":      "x = 3, y = 3\n18446744073709551615b2o!",
+			"huge cell run": "x = 3, y = 3\n9223372036854775808o3o!",
+			"huge row run":  "x = 3, y = 3\n18446744073709551615$2o!",
+			"long run":      "x = 3, y = 3\n4b$3o!",
+		}
+		for name, text := range tests {
+			_, err := gol.ReadRLE(strings.NewReader(text))
+			if err == nil {
+				t.Errorf("Expected a pattern with %s to be rejected", name)
+			}
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "gol-rle")
+		util.Check(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "glider.rle")
+		util.Check(ioutil.WriteFile(path, []byte(gliderRLE), 0644))
+
+		// The glider moves one cell down and right every 4 turns.
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 8, Threads: 4, Pattern: path, PatternAt: &util.Cell{X: 2, Y: 1}}
+		expected := []util.Cell{{X: 5, Y: 3}, {X: 6, Y: 4}, {X: 4, Y: 5}, {X: 5, Y: 5}, {X: 6, Y: 5}}
+		assertEqualBoard(t, runToFinalTurn(p), expected, p)
+
+		file, err := os.Open("out/16x16x8.rle")
+		if err != nil {
+			t.Fatalf("Expected the final world to be saved as a pattern: %v", err)
+		}
+		defer file.Close()
+		saved, err := gol.ReadRLE(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cells []util.Cell
+		for y, row := range saved.Cells {
+			for x, value := range row {
+				if value == 255 {
+					cells = append(cells, util.Cell{X: x, Y: y})
+				}
+			}
+		}
+		assertEqualBoard(t, cells, expected, p)
+	})
+}
@@ -15,6 +15,10 @@ sdlLoop:
 		if event != nil {
 			switch e := event.(type) {
 			case *sdl.KeyboardEvent:
+				// Only presses count, or every key would be sent again when it is released.
+				if e.Type != sdl.KEYDOWN {
+					break
+				}
 				switch e.Keysym.Sym {
 				case sdl.K_p:
 					keyPresses <- 'p'
@@ -24,6 +28,18 @@ sdlLoop:
 					keyPresses <- 'q'
 				case sdl.K_k:
 					keyPresses <- 'k'
+				case sdl.K_n:
+					keyPresses <- 'n'
+				case sdl.K_f:
+					keyPresses <- 'f'
+				case sdl.K_PLUS, sdl.K_EQUALS, sdl.K_KP_PLUS:
+					keyPresses <- '+'
+				case sdl.K_MINUS, sdl.K_KP_MINUS:
+					keyPresses <- '-'
+				case sdl.K_RETURN, sdl.K_KP_ENTER:
+					keyPresses <- '\n'
+				case sdl.K_0, sdl.K_1, sdl.K_2, sdl.K_3, sdl.K_4, sdl.K_5, sdl.K_6, sdl.K_7, sdl.K_8, sdl.K_9:
+					keyPresses <- rune('0' + e.Keysym.Sym - sdl.K_0)
 				}
 			}
 		}
@@ -36,6 +52,12 @@ sdlLoop:
 			switch e := event.(type) {
 			case gol.CellFlipped:
 				w.FlipPixel(e.Cell.X, e.Cell.Y)
+			case gol.CellsFlipped:
+				for _, cell := range e.Cells {
+					w.FlipPixel(cell.X, cell.Y)
+				}
+			case gol.CellStateChanged:
+				w.SetPixelValue(e.Cell.X, e.Cell.Y, e.Value)
 			case gol.TurnComplete:
 				w.RenderFrame()
 			case gol.FinalTurnComplete:
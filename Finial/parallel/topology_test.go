@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestTopology tests the 64x64 and 100x75 images on 1 and 100 turns on every bounded or twisted topology using 1, 4 and 8 worker threads.
+func TestTopology(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 100, ImageHeight: 75},
+	}
+	for _, p := range tests {
+		for _, topology := range []string{"plane", "cylinder", "vcylinder", "klein", "projective"} {
+			for _, turns := range []int{1, 100} {
+				p.Turns = turns
+				p.Topology = topology
+				expectedAlive := readAliveCells(
+					"check/images/"+fmt.Sprintf("%vx%vx%v-%v.pgm", p.ImageWidth, p.ImageHeight, turns, topology),
+					p.ImageWidth,
+					p.ImageHeight,
+				)
+				for _, threads := range []int{1, 4, 8} {
+					p.Threads = threads
+					testName := fmt.Sprintf("%s-%dx%dx%d-%d", p.Topology, p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+					t.Run(testName, func(t *testing.T) {
+						events := make(chan gol.Event)
+						go gol.Run(p, events, nil)
+						var cells []util.Cell
+						for event := range events {
+							switch e := event.(type) {
+							case gol.FinalTurnComplete:
+								cells = e.Alive
+							}
+						}
+						assertEqualBoard(t, cells, expectedAlive, p)
+					})
+				}
+			}
+		}
+	}
+}
+
+// TestTopologyNeighbour checks how coordinates just outside the board are mapped for each topology.
+func TestTopologyNeighbour(t *testing.T) {
+	tests := []struct {
+		topology util.Topology
+		x, y     int
+		nx, ny   int
+		ok       bool
+	}{
+		{util.Torus, -1, -1, 9, 4, true},
+		{util.Plane, -1, 2, 0, 0, false},
+		{util.Plane, 3, 5, 0, 0, false},
+		{util.Cylinder, 10, 2, 0, 2, true},
+		{util.Cylinder, 3, -1, 0, 0, false},
+		{util.VCylinder, 3, -1, 3, 4, true},
+		{util.VCylinder, -1, 2, 0, 0, false},
+		{util.Klein, 10, 1, 0, 1, true},
+		{util.Klein, 2, -1, 7, 4, true},
+		{util.Projective, 10, 1, 0, 3, true},
+		{util.Projective, 2, 5, 7, 0, true},
+	}
+	for _, test := range tests {
+		nx, ny, ok := test.topology.Neighbour(test.x, test.y, 10, 5)
+		if ok != test.ok || (ok && (nx != test.nx || ny != test.ny)) {
+			t.Errorf("%v.Neighbour(%d, %d) = (%d, %d, %v), expected (%d, %d, %v)", test.topology, test.x, test.y, nx, ny, ok, test.nx, test.ny, test.ok)
+		}
+	}
+	if _, err := util.ParseTopology("sphere"); err == nil {
+		t.Error("ParseTopology(\"sphere\") should have failed")
+	}
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultRule is Conway's Game of Life in B/S notation.
+const DefaultRule = "B3/S23"
+
+// Rule is a parsed life-like or Generations rule. Born[n] is true if a dead cell
+// with n alive neighbours becomes alive, Survive[n] is true if an alive cell with
+// n alive neighbours stays alive. States is the total number of cell states; with
+// more than 2 states an alive cell that does not survive passes through
+// States-2 decay states before it is dead, and only alive cells count as neighbours.
+//
+// In the world, alive is 255, dead is 0 and decay state k is stored as the grey
+// level 255 - k*255/(States-1), so older cells are darker.
+type Rule struct {
+	Born    [9]bool
+	Survive [9]bool
+	States  int
+	decay   []uint8 // decay[v] is the value that follows v for an alive cell that dies or a decaying cell
+}
+
+// ParseRule parses a rulestring such as "B3/S23", "B36/S23", "B2/S" or the
+// Generations form "B2/S/C3". An empty string gives the default Conway rule.
+func ParseRule(rulestring string) (Rule, error) {
+	var rule Rule
+	if rulestring == "" {
+		rulestring = DefaultRule
+	}
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(rulestring)), "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return rule, fmt.Errorf("invalid rule %q: expected the form B<digits>/S<digits> or B<digits>/S<digits>/C<states>", rulestring)
+	}
+	rule.States = 2
+	if len(parts) == 3 {
+		states, err := strconv.Atoi(strings.TrimPrefix(parts[2], "C"))
+		if !strings.HasPrefix(parts[2], "C") || err != nil || states < 2 || states > 256 {
+			return rule, fmt.Errorf("invalid rule %q: %q must be C followed by 2 to 256 states", rulestring, parts[2])
+		}
+		rule.States = states
+		parts = parts[:2]
+	}
+	seenB, seenS := false, false
+	for _, part := range parts {
+		if part == "" {
+			return rule, fmt.Errorf("invalid rule %q: empty section", rulestring)
+		}
+		var counts *[9]bool
+		switch part[0] {
+		case 'B':
+			if seenB {
+				return rule, fmt.Errorf("invalid rule %q: B given twice", rulestring)
+			}
+			seenB = true
+			counts = &rule.Born
+		case 'S':
+			if seenS {
+				return rule, fmt.Errorf("invalid rule %q: S given twice", rulestring)
+			}
+			seenS = true
+			counts = &rule.Survive
+		default:
+			return rule, fmt.Errorf("invalid rule %q: section %q must start with B or S", rulestring, part)
+		}
+		for _, digit := range part[1:] {
+			if digit < '0' || digit > '8' {
+				return rule, fmt.Errorf("invalid rule %q: %q is not a neighbour count", rulestring, digit)
+			}
+			counts[digit-'0'] = true
+		}
+	}
+
+	rule.decay = make([]uint8, 256)
+	previous := uint8(255)
+	for k := 1; k < rule.States; k++ {
+		value := rule.StateValue(k)
+		rule.decay[previous] = value
+		previous = value
+	}
+	return rule, nil
+}
+
+// StateValue returns the byte stored in the world for decay state k, where
+// state 0 is alive and state States-1 is dead.
+func (r Rule) StateValue(k int) uint8 {
+	return uint8(255 - k*255/(r.States-1))
+}
+
+// NextState returns the value of a cell in the next turn from its current value
+// and its number of alive neighbours.
+func (r Rule) NextState(current uint8, aliveNeighbors int) uint8 {
+	switch current {
+	case 255:
+		if r.Survive[aliveNeighbors] {
+			return 255
+		}
+		return r.decay[255]
+	case 0:
+		if r.Born[aliveNeighbors] {
+			return 255
+		}
+		return 0
+	default:
+		return r.decay[current]
+	}
+}
+
+// IsDecaying reports whether a cell value is one of the intermediate decay states.
+func IsDecaying(value uint8) bool {
+	return value != 0 && value != 255
+}
+
+// String returns the rule in B/S notation.
+func (r Rule) String() string {
+	var b, s strings.Builder
+	for n := 0; n <= 8; n++ {
+		if r.Born[n] {
+			fmt.Fprint(&b, n)
+		}
+		if r.Survive[n] {
+			fmt.Fprint(&s, n)
+		}
+	}
+	if r.States > 2 {
+		return fmt.Sprintf("B%s/S%s/C%d", b.String(), s.String(), r.States)
+	}
+	return "B" + b.String() + "/S" + s.String()
+}